@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+	"github.com/forbiddenlink/gif-my-code/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+var languagesJSON bool
+
+var languagesCmd = &cobra.Command{
+	Use:   "languages",
+	Short: "List supported languages",
+	Long: `List every language chroma can highlight with the names --lang accepts,
+and the file extensions auto-detection maps to each one.`,
+	Args: cobra.NoArgs,
+	RunE: runLanguages,
+}
+
+func init() {
+	languagesCmd.Flags().BoolVar(&languagesJSON, "json", false, "Print the languages as JSON")
+	rootCmd.AddCommand(languagesCmd)
+}
+
+// languageInfo is a chroma lexer plus the extensions detection maps to it
+type languageInfo struct {
+	highlight.Language
+	Extensions []string `json:"extensions"`
+}
+
+func runLanguages(cmd *cobra.Command, args []string) error {
+	// Group detected extensions by the lexer their language resolves to
+	extensions := map[string][]string{}
+	for ext, lang := range parser.Extensions() {
+		if name := highlight.LanguageName(lang); name != "" {
+			extensions[name] = append(extensions[name], ext)
+		}
+	}
+
+	var infos []languageInfo
+	for _, lang := range highlight.ListLanguages() {
+		exts := extensions[lang.Name]
+		sort.Strings(exts)
+		if exts == nil {
+			exts = []string{}
+		}
+		if lang.Aliases == nil {
+			lang.Aliases = []string{}
+		}
+		if lang.Filenames == nil {
+			lang.Filenames = []string{}
+		}
+		infos = append(infos, languageInfo{Language: lang, Extensions: exts})
+	}
+
+	if languagesJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LANGUAGE\tALIASES\tEXTENSIONS")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", info.Name, strings.Join(info.Aliases, ", "), strings.Join(info.Extensions, " "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d languages\n", len(infos))
+	return nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/forbiddenlink/gif-my-code/internal/animator"
+	"github.com/forbiddenlink/gif-my-code/internal/encoder"
+)
+
+// quality is one rung of the --max-size ladder: the settings an attempt
+// renders and encodes with
+type quality struct {
+	colors int     // GIF palette size (0 when the format has no palette)
+	fps    int     // Frame rate
+	merge  float64 // Merge frames that differ in less than this fraction of pixels
+	scale  float64 // Scale of the width, font size and height limits
+}
+
+// qualityLadder returns the settings --max-size tries, from what was asked
+// for down to the cheapest. Each rung keeps the savings of the one before
+// and gives up the least visible quality left: colours first, then
+// smoothness, then size.
+func qualityLadder(fps int) []quality {
+	return []quality{
+		{colors: 256, fps: fps, scale: 1},
+		{colors: 128, fps: fps, scale: 1},
+		{colors: 128, fps: min(fps, 20), scale: 1},
+		{colors: 64, fps: min(fps, 15), merge: 0.002, scale: 1},
+		{colors: 64, fps: min(fps, 15), merge: 0.005, scale: 0.75},
+		{colors: 32, fps: min(fps, 10), merge: 0.01, scale: 0.5},
+	}
+}
+
+// forFormat clears the settings a format ignores, so rungs that would
+// encode the same file can be skipped
+func (q quality) forFormat(name string) quality {
+	if name != encoder.FormatGIF {
+		q.colors = 0
+	}
+	// Exact-timing formats keep every frame and stills have just one
+	if encoder.ExactTiming(name) || encoder.IsStill(name) {
+		q.merge = 0
+	}
+	if encoder.IsStill(name) {
+		q.fps = fps
+	}
+	return q
+}
+
+// String describes what the rung gives up compared with the flags
+func (q quality) String() string {
+	var parts []string
+	if q.colors > 0 && q.colors < 256 {
+		parts = append(parts, fmt.Sprintf("%d colors", q.colors))
+	}
+	if q.fps < fps {
+		parts = append(parts, fmt.Sprintf("%d fps", q.fps))
+	}
+	if q.merge > 0 {
+		parts = append(parts, fmt.Sprintf("merging frames under %g%% different", q.merge*100))
+	}
+	if q.scale < 1 {
+		parts = append(parts, fmt.Sprintf("%.0f%% scale (%dpx wide)", q.scale*100, scaled(width, q.scale)))
+	}
+	if len(parts) == 0 {
+		return "the requested settings"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// apply returns the animation and encoder settings for the rung
+func (q quality) apply(config animator.Config, opts encoder.Options) (animator.Config, encoder.Options) {
+	// Typing advances a whole number of characters per frame, so fewer
+	// frames a second type more each to keep the characters per second
+	cps := float64(animator.CharsPerFrame(config.Speed) * config.FPS)
+	config.Speed = math.Max(1, math.Round(cps/float64(q.fps))) / 2
+	config.FPS = q.fps
+	config.Width = scaled(config.Width, q.scale)
+	config.FontSize *= q.scale
+	config.MinHeight = scaled(config.MinHeight, q.scale)
+	config.MaxHeight = scaled(config.MaxHeight, q.scale)
+
+	opts.FPS = q.fps
+	opts.Colors = q.colors
+	opts.MergeBelow = q.merge
+	return config, opts
+}
+
+// scaled multiplies a pixel size by scale, keeping 0 (unset) as 0
+func scaled(px int, scale float64) int {
+	return int(math.Round(float64(px) * scale))
+}
+
+// fitSize builds and encodes the animation at each rung of the quality
+// ladder until the output is at most limit bytes. The output holds the
+// last attempt either way.
+func fitSize(build func(animator.Config) (*animator.Animation, error), config animator.Config,
+	opts encoder.Options, limit int64) (*animator.Animation, *encoder.Stats, error) {
+	fmt.Printf("📦 Fitting the output into %s\n", formatSize(limit))
+
+	var tried quality
+	var stats *encoder.Stats
+	for i, q := range qualityLadder(fps) {
+		q = q.forFormat(format)
+		if i > 0 && q == tried {
+			continue
+		}
+		if stats != nil {
+			fmt.Printf("🔁 %s is over %s - retrying with %s\n", formatSize(stats.Bytes), formatSize(limit), q)
+		}
+		tried = q
+
+		rungConfig, rungOpts := q.apply(config, opts)
+		anim, err := build(rungConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stats, err = encodeFrames(anim, rungOpts); err != nil {
+			return nil, nil, err
+		}
+		if stats.Bytes <= limit {
+			fmt.Printf("📦 %s fits --max-size %s with %s\n", formatSize(stats.Bytes), formatSize(limit), q)
+			return anim, stats, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("cannot fit %s into --max-size %s: even the cheapest settings (%s) give %s - try a shorter snippet, a smaller --width or another --format",
+		output, formatSize(limit), tried, formatSize(stats.Bytes))
+}
+
+// formatSize prints a byte count in the binary units --max-size accepts
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
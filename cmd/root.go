@@ -1,21 +1,33 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"image/color"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/forbiddenlink/gif-my-code/internal/animator"
+	"github.com/forbiddenlink/gif-my-code/internal/cast"
 	"github.com/forbiddenlink/gif-my-code/internal/encoder"
 	"github.com/forbiddenlink/gif-my-code/internal/highlight"
 	"github.com/forbiddenlink/gif-my-code/internal/parser"
+	"github.com/forbiddenlink/gif-my-code/internal/render"
 	"github.com/spf13/cobra"
 )
 
 var (
 	theme        string
+	themeFiles   []string
 	speed        float64
 	output       string
+	format       string
 	width        int
 	fontSize     float64
 	language     string
@@ -26,10 +38,29 @@ var (
 	hiDPI        bool
 	lineNumbers  bool
 	laser        bool
+	minHeight    int
+	maxHeight    int
+	overflow     string
+	endScroll    string
+	wrap         string
+	fontName     string
+	fontDir      string
+	background   string
+	strict       bool
+	paletteMode  string
+	dither       string
+	delta        bool
+	loop         string
+	holdStart    time.Duration
+	holdEnd      time.Duration
+	boomerang    bool
+	poster       bool
+	jobs         int
+	maxSize      string
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "gif-my-code [file]",
+	Use:   "gif-my-code [file|-]",
 	Short: "Convert code snippets into beautiful animated GIFs",
 	Long: `gif-my-code creates animated GIFs of your code with syntax highlighting and typing effects.
 	
@@ -38,25 +69,54 @@ Perfect for:
   • README files
   • Documentation
   • Tutorials
-  • Portfolio demos`,
+  • Portfolio demos
+
+When no file is given (or the file is "-"), code is read from stdin:
+
+  git show HEAD:main.go | gif-my-code --lang go
+
+An asciinema recording (.cast) is replayed in the window instead of typed:
+
+  gif-my-code session.cast -o session.gif`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: run,
 }
 
 func init() {
 	rootCmd.Flags().StringVarP(&theme, "theme", "t", "dracula", "Color theme")
+	rootCmd.PersistentFlags().StringSliceVar(&themeFiles, "theme-file", nil, "Load a theme from a chroma XML, YAML/JSON or VS Code theme file (repeatable)")
 	rootCmd.Flags().Float64VarP(&speed, "speed", "s", 1.0, "Typing speed multiplier")
 	rootCmd.Flags().StringVarP(&output, "output", "o", "code.gif", "Output file path")
+	rootCmd.Flags().StringVar(&format, "format", "", "Output format: gif, apng, webp, mp4, webm, svg, cast (asciinema), png (still) or frames (PNG sequence) (default: from the output extension)")
 	rootCmd.Flags().IntVarP(&width, "width", "w", 800, "Image width in pixels")
 	rootCmd.Flags().Float64VarP(&fontSize, "font-size", "f", 16, "Font size")
 	rootCmd.Flags().StringVarP(&language, "lang", "l", "", "Force language (auto-detect if not provided)")
+	rootCmd.Flags().BoolVar(&strict, "strict", true, "Reject unknown themes and languages (false falls back with a warning)")
+	rootCmd.Flags().StringVar(&fontName, "font", "", "Font file (TTF/OTF) or installed family name (default Go Mono)")
+	rootCmd.Flags().StringVar(&fontDir, "font-dir", "", "Directory holding the font family's regular/bold/italic files")
+	rootCmd.Flags().StringVar(&background, "background", "theme", "Window background: theme (flat theme color) or gradient")
 	rootCmd.Flags().BoolVar(&noCursor, "no-cursor", false, "Disable cursor animation")
 	rootCmd.Flags().IntVar(&fps, "fps", 30, "Frames per second")
+	rootCmd.Flags().StringVar(&loop, "loop", "forever", "How often the animation plays: forever, once, or a number of plays")
+	rootCmd.Flags().DurationVar(&holdStart, "hold-start", 0, "How long to show the empty editor before typing (e.g. 500ms)")
+	rootCmd.Flags().DurationVar(&holdEnd, "hold-end", 2*time.Second, "How long to show the finished code")
+	rootCmd.Flags().BoolVar(&boomerang, "boomerang", false, "Erase the code again after the end hold")
+	rootCmd.Flags().BoolVar(&poster, "poster", false, "Also write a still PNG of the finished code next to the output (<name>-poster.png)")
+	rootCmd.Flags().IntVar(&jobs, "jobs", runtime.GOMAXPROCS(0), "Frames to render in parallel")
+	rootCmd.Flags().StringVar(&maxSize, "max-size", "", "Largest acceptable output (e.g. 5MB, 500KB); cheaper settings are tried until it fits")
+	rootCmd.Flags().StringVar(&paletteMode, "palette", "global", "GIF palette: global (one adaptive palette) or local (one per frame)")
+	rootCmd.Flags().BoolVar(&delta, "delta", true, "Store only the changed region of each frame (smaller files)")
+	rootCmd.Flags().StringVar(&dither, "dither", "none", "GIF dithering: none, floyd (Floyd-Steinberg), or ordered")
 	rootCmd.Flags().StringVar(&highlightStr, "highlight", "", "Lines to highlight (e.g., '5,7-9')")
 	rootCmd.Flags().StringVar(&windowStyle, "window", "none", "Window style: macos, windows, or none")
 	rootCmd.Flags().BoolVar(&hiDPI, "hidpi", false, "Render at 2x resolution (Retina scale)")
 	rootCmd.Flags().BoolVar(&lineNumbers, "line-numbers", false, "Show line numbers")
 	rootCmd.Flags().BoolVar(&laser, "laser", true, "Use fluid laser reveal animation instead of typing")
+	rootCmd.Flags().IntVar(&minHeight, "min-height", 0, "Minimum image height in pixels (0 = fit content)")
+	rootCmd.Flags().IntVar(&maxHeight, "max-height", 0, "Maximum image height in pixels (0 = unlimited)")
+	rootCmd.Flags().StringVar(&overflow, "overflow", "error", "When code exceeds --max-height: error, clip, or scroll")
+	rootCmd.Flags().StringVar(&wrap, "wrap", "soft", "Long line handling: soft, none, or clip")
+	rootCmd.Flags().StringVar(&endScroll, "end-scroll", "none", "Where a scrolling viewport settles at the end: none, top, or highlight")
 }
 
 func run(cmd *cobra.Command, args []string) error {
@@ -66,25 +126,104 @@ func run(cmd *cobra.Command, args []string) error {
 	var err error
 
 	// Read input (file or stdin)
-	if len(args) == 0 {
-		// TODO: Read from stdin
-		return fmt.Errorf("stdin support coming soon - please provide a file path")
-	} else {
+	if len(args) > 0 {
 		filePath = args[0]
+	}
+	fromStdin := parser.IsStdin(filePath)
+	if fromStdin {
+		filePath = ""
+		code, err = parser.ReadStdin()
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+	} else {
 		code, err = parser.ReadFile(filePath)
 		if err != nil {
 			return fmt.Errorf("failed to read file: %w", err)
 		}
+	}
+
+	// A .cast file is a terminal recording to replay rather than code
+	recording := !fromStdin && cast.IsRecording(filePath)
+
+	// Detect language from file name and content if not provided
+	if language == "" && !recording {
+		detected := parser.DetectLanguage(filePath, code)
+		if fromStdin && detected.Confidence == 0 {
+			return fmt.Errorf("could not detect language from stdin - please pass --lang")
+		}
+		if detected.IsGuess() {
+			fmt.Fprintf(os.Stderr, "⚠️  Guessed language %q from %s (%.0f%% confidence) - pass --lang to override\n",
+				detected.Language, detected.Source, detected.Confidence*100)
+		}
+		lang = detected.Language
+	} else {
+		lang = language
+	}
+
+	loopCount, err := encoder.ParseLoop(loop)
+	if err != nil {
+		return err
+	}
+	if holdStart < 0 || holdEnd < 0 {
+		return fmt.Errorf("--hold-start and --hold-end must not be negative")
+	}
+	// Encoders check this too, but SVG and cast output bypass them
+	if fps <= 0 {
+		return fmt.Errorf("--fps must be positive, got %d", fps)
+	}
+
+	// Without --format the output's extension decides; with it, the
+	// default output name takes the format's extension
+	if format == "" {
+		format = encoder.FormatForPath(output)
+	} else if err := encoder.ValidateFormat(format); err != nil {
+		return err
+	} else if !cmd.Flags().Changed("output") {
+		output = strings.TrimSuffix(output, filepath.Ext(output)) + encoder.Extension(format)
+	}
+
+	var sizeLimit int64
+	if maxSize != "" {
+		if sizeLimit, err = parser.ParseSize(maxSize); err != nil {
+			return fmt.Errorf("--max-size: %w", err)
+		}
+		if encoder.FromCode(format) {
+			return fmt.Errorf("--max-size needs a rendered format - %s output is written from the code and has no quality settings to lower", format)
+		}
+	}
+
+	// Register custom themes. Without an explicit --theme the last one is used
+	loaded, err := loadThemeFiles()
+	if err != nil {
+		return err
+	}
+	for i, style := range loaded {
+		fmt.Printf("🖌️  Loaded theme %q from %s\n", style.Name, themeFiles[i])
+		if !cmd.Flags().Changed("theme") {
+			theme = style.Name
+		}
+	}
 
-		// Detect language from file extension if not provided
-		if language == "" {
-			lang = parser.DetectLanguage(filePath)
-		} else {
-			lang = language
+	// Reject typos instead of silently falling back to chroma's defaults
+	if err := checkName(highlight.CheckTheme(theme), styles.Fallback.Name); err != nil {
+		return err
+	}
+	if !recording {
+		if err := checkName(highlight.CheckLanguage(lang), "plain text"); err != nil {
+			return err
 		}
 	}
 
-	fmt.Printf("📖 Reading %s (%s)\n", filepath.Base(filePath), lang)
+	displayName := "<stdin>"
+	if !fromStdin {
+		displayName = filepath.Base(filePath)
+	}
+	if recording {
+		return replay(code, displayName, loopCount, sizeLimit)
+	}
+
+	fmt.Printf("📖 Reading %s (%s)\n", displayName, lang)
 	fmt.Printf("🎨 Theme: %s\n", theme)
 	fmt.Printf("⚡ Speed: %.1fx\n", speed)
 
@@ -95,12 +234,58 @@ func run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to highlight code: %w", err)
 	}
 
+	config, err := animationConfig(lang)
+	if err != nil {
+		return err
+	}
+	build := func(config animator.Config) (*animator.Animation, error) {
+		anim, err := animator.NewAnimation(highlighted, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate frames: %w", err)
+		}
+		return anim, nil
+	}
+	palette := render.ThemePalette(highlighted.Style, highlighted.Tokens)
+	return writeOutput(build, config, palette, loopCount, sizeLimit, displayName)
+}
+
+// replay renders the asciicast recording in data instead of typing code
+func replay(data, name string, loopCount int, sizeLimit int64) error {
+	if encoder.FromCode(format) {
+		return fmt.Errorf("%s output is written from source code - render a recording as frames instead (e.g. gif)", format)
+	}
+	rec, err := cast.Read(strings.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	fmt.Printf("📼 Replaying %s (%dx%d terminal, %.1fs)\n", name, rec.Header.Width, rec.Header.Height, rec.Duration())
+	fmt.Printf("🎨 Theme: %s\n", theme)
+
+	config, err := animationConfig("")
+	if err != nil {
+		return err
+	}
+	style := highlight.Style(theme)
+	build := func(config animator.Config) (*animator.Animation, error) {
+		anim, err := animator.NewPlayback(rec, style, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate frames: %w", err)
+		}
+		return anim, nil
+	}
+	return writeOutput(build, config, render.ThemePalette(style, nil), loopCount, sizeLimit, name)
+}
+
+// animationConfig collects the animation settings from the flags
+func animationConfig(lang string) (animator.Config, error) {
 	// Parse highlight lines
 	var highlightLines []int
 	if highlightStr != "" {
+		var err error
 		highlightLines, err = parser.ParseHighlightLines(highlightStr)
 		if err != nil {
-			return fmt.Errorf("invalid highlight format: %w", err)
+			return animator.Config{}, fmt.Errorf("invalid highlight format: %w", err)
 		}
 		fmt.Printf("📍 Highlighting lines: %v\n", highlightLines)
 	}
@@ -110,7 +295,7 @@ func run(cmd *cobra.Command, args []string) error {
 	if windowStyle != "none" && windowStyle != "" {
 		fmt.Printf("🪟 Window style: %s\n", windowStyle)
 	}
-	config := animator.Config{
+	return animator.Config{
 		Width:          width,
 		FontSize:       fontSize,
 		Speed:          speed,
@@ -123,28 +308,234 @@ func run(cmd *cobra.Command, args []string) error {
 		LineNumbers:    lineNumbers,
 		Language:       lang,
 		LaserReveal:    laser,
+		MinHeight:      minHeight,
+		MaxHeight:      maxHeight,
+		Overflow:       overflow,
+		EndScroll:      endScroll,
+		Wrap:           wrap,
+		Font:           fontName,
+		FontDir:        fontDir,
+		Background:     background,
+		HoldStart:      holdStart,
+		HoldEnd:        holdEnd,
+		Boomerang:      boomerang,
+		Jobs:           jobs,
+	}, nil
+}
+
+// writeOutput builds the animation from config, writes it in the output
+// format and reports the result. palette holds the colours GIF
+// quantization must keep exact. A sizeLimit above zero rebuilds the
+// animation with cheaper settings until the output fits.
+func writeOutput(build func(animator.Config) (*animator.Animation, error), config animator.Config,
+	palette []color.RGBA, loopCount int, sizeLimit int64, title string) error {
+	var anim *animator.Animation
+	var stats *encoder.Stats
+	var err error
+	if sizeLimit > 0 {
+		anim, stats, err = fitSize(build, config, encodeOptions(palette, loopCount), sizeLimit)
+		if err != nil {
+			return err
+		}
+	} else if anim, err = build(config); err != nil {
+		return err
 	}
-	frames, err := animator.GenerateFrames(highlighted, config)
-	if err != nil {
-		return fmt.Errorf("failed to generate frames: %w", err)
+
+	switch {
+	case stats != nil:
+		// Already written while fitting the size limit
+	case format == encoder.FormatSVG:
+		fmt.Printf("✒️  Animating the code as SVG text (%d frames)...\n", anim.Len())
+		stats, err = writeFile(output, func(w io.Writer) error {
+			return anim.WriteSVG(w, encoder.Plays(loopCount))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write SVG: %w", err)
+		}
+	case format == encoder.FormatCast:
+		fmt.Printf("📼 Recording the typing as an asciicast (%d frames)...\n", anim.Len())
+		stats, err = writeFile(output, func(w io.Writer) error {
+			return anim.WriteCast(w, title)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write asciicast: %w", err)
+		}
+	default:
+		stats, err = encodeFrames(anim, encodeOptions(palette, loopCount))
+		if err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("   Generated %d frames\n", len(frames))
+	if stats.Duplicates > 0 {
+		fmt.Printf("   Merged %d duplicate frames (%d written)\n", stats.Duplicates, stats.Frames)
+	}
+	if stats.Merged > 0 {
+		fmt.Printf("   Merged %d near-identical frames\n", stats.Merged)
+	}
+	if stats.Dropped > 0 {
+		fmt.Printf("   Dropped %d frames shorter than %dcs\n", stats.Dropped, encoder.MinDelay)
+	}
 
-	// Encode GIF
-	fmt.Println("🎁 Encoding GIF...")
-	if err := encoder.EncodeGIF(frames, output, fps); err != nil {
-		return fmt.Errorf("failed to encode GIF: %w", err)
+	sizeMB := float64(stats.Bytes) / 1024 / 1024
+	if delta && stats.RawBytes > 0 {
+		rawMB := float64(stats.RawBytes) / 1024 / 1024
+		saved := 100 * (1 - float64(stats.Bytes)/float64(stats.RawBytes))
+		fmt.Printf("📉 Delta encoding: %.2f MB → %.2f MB (%.0f%% smaller)\n", rawMB, sizeMB, saved)
 	}
 
-	// Get file size
-	info, _ := os.Stat(output)
-	sizeMB := float64(info.Size()) / 1024 / 1024
+	// A still of the finished code, for slides and README fallbacks
+	if poster && !encoder.IsStill(format) {
+		path := strings.TrimSuffix(output, filepath.Ext(output)) + "-poster.png"
+		img, err := anim.Poster()
+		if err != nil {
+			return err
+		}
+		if err := encoder.EncodePNG(img, path); err != nil {
+			return fmt.Errorf("failed to write poster: %w", err)
+		}
+		fmt.Printf("🖼️  Poster saved to: %s\n", path)
+	}
 
 	fmt.Printf("\n✅ Done! Saved to: %s (%.2f MB)\n", output, sizeMB)
 	return nil
 }
 
+// encodeOptions collects the encoder settings from the flags
+func encodeOptions(palette []color.RGBA, loopCount int) encoder.Options {
+	return encoder.Options{
+		FPS:         fps,
+		Palette:     paletteMode,
+		Dither:      dither,
+		ThemeColors: palette,
+		Delta:       delta,
+		LoopCount:   loopCount,
+	}
+}
+
+// encodeFrames renders the animation's frames and encodes them in the
+// output format. Video falls back to GIF when ffmpeg is missing.
+func encodeFrames(anim *animator.Animation, encodeOpts encoder.Options) (*encoder.Stats, error) {
+	enc, err := encoder.Create(output, format, encodeOpts)
+	if errors.Is(err, encoder.ErrFFmpegNotFound) {
+		// Video needs ffmpeg; a GIF can always be written
+		output = strings.TrimSuffix(output, filepath.Ext(output)) + encoder.Extension(encoder.FormatGIF)
+		fmt.Fprintf(os.Stderr, "⚠️  %v - falling back to GIF: %s\n", err, output)
+		format = encoder.FormatGIF
+		enc, err = encoder.Create(output, format, encodeOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output: %w", err)
+	}
+
+	// Browsers slow down delays under 2cs, so faster frames get dropped
+	if encodeOpts.FPS > encoder.MaxFPS && !encoder.ExactTiming(format) {
+		fmt.Fprintf(os.Stderr, "⚠️  --fps %d needs %.1fcs frame delays but browsers only honor %dcs or more - dropping frames to play at %d fps\n",
+			encodeOpts.FPS, 100/float64(encodeOpts.FPS), encoder.MinDelay, encoder.MaxFPS)
+	}
+
+	// Frames are rendered and encoded one at a time
+	if encoder.IsStill(format) {
+		fmt.Printf("📸 Rendering the finished code as %s...\n", strings.ToUpper(format))
+	} else {
+		fmt.Printf("🎁 Rendering and encoding %d frames as %s...\n", anim.Len(), strings.ToUpper(format))
+	}
+	stats, err := encodeAnimation(enc, anim, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", strings.ToUpper(format), err)
+	}
+	return stats, nil
+}
+
+// writeFile creates the file at path and fills it with write. A failed
+// write removes the partial file.
+func writeFile(path string, write func(w io.Writer) error) (*encoder.Stats, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &encoder.Stats{Bytes: info.Size()}, nil
+}
+
+// encodeAnimation streams the animation's frames into enc and finishes
+// the file
+func encodeAnimation(enc encoder.Encoder, anim *animator.Animation, format string) (*encoder.Stats, error) {
+	if err := writeFrames(enc, anim, format); err != nil {
+		encoder.Abort(enc)
+		return nil, err
+	}
+	return enc.Close()
+}
+
+// writeFrames renders the frames of anim that format needs into enc
+func writeFrames(enc encoder.Encoder, anim *animator.Animation, format string) error {
+	// Still images only show the finished code
+	if encoder.IsStill(format) {
+		img, err := anim.Poster()
+		if err != nil {
+			return err
+		}
+		return enc.WriteFrame(img)
+	}
+
+	// For GIFs a handful of frames are rendered up front to build the
+	// global palette
+	if format == encoder.FormatGIF && paletteMode != encoder.PaletteLocal {
+		for _, i := range encoder.SampleIndices(anim.Len()) {
+			img, err := anim.Render(i)
+			if err != nil {
+				return err
+			}
+			enc.Sample(img)
+		}
+	}
+	for img, err := range anim.Frames() {
+		if err != nil {
+			return err
+		}
+		if err := enc.WriteFrame(img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadThemeFiles registers every --theme-file with chroma
+func loadThemeFiles() ([]*chroma.Style, error) {
+	var loaded []*chroma.Style
+	for _, path := range themeFiles {
+		style, err := highlight.LoadThemeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, style)
+	}
+	return loaded, nil
+}
+
+// checkName returns a lookup error in strict mode and otherwise prints it as
+// a warning naming the fallback that will be used instead
+func checkName(err error, fallback string) error {
+	if err == nil || strict {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  %v - falling back to %q\n", err, fallback)
+	return nil
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+	"github.com/forbiddenlink/gif-my-code/internal/render"
+	"github.com/spf13/cobra"
+)
+
+var (
+	themesJSON     bool
+	themesPreview  bool
+	previewOutput  string
+	previewColumns int
+)
+
+// previewSnippet is the code shown for every theme in the contact sheet.
+// It touches the common token types: keywords, strings, numbers, comments,
+// functions and operators.
+const previewSnippet = `package main
+
+import "fmt"
+
+// greet returns a friendly message
+func greet(name string, n int) string {
+	return fmt.Sprintf("Hi %s #%d", name, n*2)
+}
+
+func main() {
+	for i := 0; i < 3; i++ {
+		fmt.Println(greet("gopher", i))
+	}
+}
+`
+
+var themesCmd = &cobra.Command{
+	Use:   "themes",
+	Short: "List available color themes",
+	Long: `List every color theme, including any loaded with --theme-file.
+
+Pass --preview to render a contact sheet PNG with a sample snippet in
+every theme, so you can pick one by eye:
+
+  gif-my-code themes --preview -o themes.png`,
+	Args: cobra.NoArgs,
+	RunE: runThemes,
+}
+
+func init() {
+	themesCmd.Flags().BoolVar(&themesJSON, "json", false, "Print the themes as JSON")
+	themesCmd.Flags().BoolVar(&themesPreview, "preview", false, "Render a contact sheet PNG of every theme")
+	themesCmd.Flags().StringVarP(&previewOutput, "output", "o", "themes.png", "Contact sheet output path")
+	themesCmd.Flags().IntVar(&previewColumns, "columns", 4, "Themes per row in the contact sheet")
+	rootCmd.AddCommand(themesCmd)
+}
+
+// themeInfo is the JSON form of a theme
+type themeInfo struct {
+	Name       string `json:"name"`
+	Background string `json:"background,omitempty"`
+	Foreground string `json:"foreground,omitempty"`
+}
+
+func runThemes(cmd *cobra.Command, args []string) error {
+	if _, err := loadThemeFiles(); err != nil {
+		return err
+	}
+	names := highlight.ListThemes()
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	if themesPreview {
+		if err := writeContactSheet(names); err != nil {
+			return err
+		}
+	}
+
+	if themesJSON {
+		infos := make([]themeInfo, 0, len(names))
+		for _, name := range names {
+			base := styles.Get(name).Get(chroma.Background)
+			info := themeInfo{Name: name}
+			if base.Background.IsSet() {
+				info.Background = base.Background.String()
+			}
+			if base.Colour.IsSet() {
+				info.Foreground = base.Colour.String()
+			}
+			infos = append(infos, info)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+
+	if themesPreview {
+		fmt.Printf("✅ Saved %d theme previews to: %s\n", len(names), previewOutput)
+		return nil
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// writeContactSheet renders the preview snippet in every theme and saves
+// the tiles as one PNG
+func writeContactSheet(names []string) error {
+	fmt.Fprintf(os.Stderr, "🎨 Rendering %d theme previews...\n", len(names))
+
+	tiles := make([]*image.RGBA, 0, len(names))
+	for _, name := range names {
+		code, err := highlight.Highlight(previewSnippet, "go", name)
+		if err != nil {
+			return fmt.Errorf("failed to highlight preview: %w", err)
+		}
+		renderer, err := render.NewRenderer(render.Options{
+			Width:       480,
+			FontSize:    13,
+			WindowStyle: "macos",
+			Theme:       name,
+			Wrap:        render.WrapSoft,
+			Style:       code.Style,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create renderer: %w", err)
+		}
+		tile, err := renderer.RenderPreview(code.Tokens)
+		if err != nil {
+			return fmt.Errorf("failed to render %s preview: %w", name, err)
+		}
+		tiles = append(tiles, tile)
+	}
+
+	sheet, err := render.ContactSheet(tiles, names, previewColumns)
+	if err != nil {
+		return fmt.Errorf("failed to build contact sheet: %w", err)
+	}
+
+	f, err := os.Create(previewOutput)
+	if err != nil {
+		return fmt.Errorf("failed to create preview: %w", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, sheet); err != nil {
+		return fmt.Errorf("failed to encode preview: %w", err)
+	}
+	return nil
+}
@@ -3,13 +3,18 @@ module github.com/forbiddenlink/gif-my-code
 go 1.25.0
 
 require (
-	github.com/alecthomas/chroma/v2 v2.23.1 // indirect
+	github.com/alecthomas/chroma/v2 v2.23.1
+	github.com/fogleman/gg v1.3.0
+	github.com/spf13/cobra v1.10.2
+	golang.org/x/image v0.36.0
+	golang.org/x/text v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/dlclark/regexp2 v1.11.5 // indirect
-	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.10.2 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
 	github.com/watzon/goshot v0.7.1 // indirect
-	golang.org/x/image v0.36.0 // indirect
 )
@@ -3,8 +3,16 @@ package animator
 import (
 	"fmt"
 	"image"
+	"io"
+	"iter"
 	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/cast"
 	"github.com/forbiddenlink/gif-my-code/internal/highlight"
 	"github.com/forbiddenlink/gif-my-code/internal/render"
 )
@@ -23,38 +31,97 @@ type Config struct {
 	LineNumbers    bool
 	Language       string
 	LaserReveal    bool
+	MinHeight      int
+	MaxHeight      int
+	Overflow       string
+	EndScroll      string // Where the viewport settles during the final hold: "none", "top", "highlight"
+	Wrap           string
+	Font           string
+	FontDir        string
+	Background     string
+	HoldStart      time.Duration // Empty editor shown before typing starts
+	HoldEnd        time.Duration // Finished code shown after typing ends
+	Boomerang      bool          // Erase the code again after the end hold
+	Jobs           int           // Frames rendered concurrently (0 = GOMAXPROCS)
 }
 
-// GenerateFrames creates all animation frames
-func GenerateFrames(code *highlight.HighlightedCode, config Config) ([]*image.RGBA, error) {
-	// Create renderer with highlight config and visual enhancements
-	renderer, err := render.NewRenderer(config.Width, config.FontSize, config.HighlightLines, config.WindowStyle, config.Theme, config.HiDPI, config.LineNumbers, config.Language, config.LaserReveal)
+// Final hold camera moves for scrolling viewports
+const (
+	EndScrollNone      = "none"
+	EndScrollTop       = "top"
+	EndScrollHighlight = "highlight"
+)
+
+// frame describes one frame of an animation before it is rendered
+type frame struct {
+	cursorPos  int
+	showCursor bool
+	progress   float64
+	scroll     float64
+	tokens     []highlight.Token // Drawn instead of the animation's tokens, for recorded screens
+}
+
+// Animation is the plan for every frame of an animation. Frames are only
+// rendered when asked for, so an animation of any length can be streamed
+// to an encoder without holding all of its frames in memory.
+type Animation struct {
+	renderer *render.Renderer
+	tokens   []highlight.Token
+	style    *chroma.Style
+	frames   []frame
+	poster   frame // The finished code, as the end hold settles
+	fps      int
+	jobs     int
+}
+
+// NewAnimation creates the renderer and plans the frames for code
+func NewAnimation(code *highlight.HighlightedCode, config Config) (*Animation, error) {
+	renderer, err := newRenderer(config, code.Style)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create renderer: %w", err)
+		return nil, err
 	}
 
-	// Calculate total characters
-	totalChars := 0
-	for _, token := range code.Tokens {
-		totalChars += len([]rune(token.Text))
+	// Size the canvas to the final layout rather than a fixed height
+	if err := renderer.FitContent(code.Tokens); err != nil {
+		return nil, err
+	}
+
+	switch config.EndScroll {
+	case "", EndScrollNone, EndScrollTop, EndScrollHighlight:
+	default:
+		return nil, fmt.Errorf("unknown end scroll %q (want %s, %s or %s)", config.EndScroll, EndScrollNone, EndScrollTop, EndScrollHighlight)
 	}
 
+	// Calculate total characters
+	totalChars := runeCount(code.Tokens)
+
 	// Calculate characters per frame based on speed
-	charsPerFrame := int(math.Max(1, 2*config.Speed))
+	charsPerFrame := CharsPerFrame(config.Speed)
 
 	// Calculate cursor blink interval (blink every 15 frames = 0.5 seconds at 30fps)
-	cursorBlinkInterval := config.FPS / 2
+	cursorBlinkInterval := max(1, config.FPS/2)
 
 	// Calculate total frames to estimate animation progress
 	typingFrames := (totalChars / charsPerFrame) + 1
-	finalFrameCount := config.FPS * 2
+	holdStartFrames := durationFrames(config.HoldStart, config.FPS)
+	finalFrameCount := durationFrames(config.HoldEnd, config.FPS)
 	totalFrames := typingFrames + finalFrameCount
 
-	frames := []*image.RGBA{}
+	frames := []frame{}
 	cursorVisible := true
 	frameCount := 0
 
-	// Generate typing frames
+	// Show the empty editor with a blinking cursor before typing starts
+	for i := 0; i < holdStartFrames; i++ {
+		frames = append(frames, frame{
+			cursorPos:  0,
+			showCursor: config.ShowCursor && (i/cursorBlinkInterval)%2 == 0,
+			scroll:     renderer.CursorScroll(code.Tokens, 0),
+		})
+	}
+	typingStart := len(frames)
+
+	// Plan typing frames
 	for charPos := 0; charPos <= totalChars; charPos += charsPerFrame {
 		if charPos > totalChars {
 			charPos = totalChars
@@ -65,28 +132,390 @@ func GenerateFrames(code *highlight.HighlightedCode, config Config) ([]*image.RG
 			cursorVisible = !cursorVisible
 		}
 
-		showCursor := config.ShowCursor && cursorVisible
-		progress := float64(frameCount) / float64(totalFrames)
+		frames = append(frames, frame{
+			cursorPos:  charPos,
+			showCursor: config.ShowCursor && cursorVisible,
+			progress:   float64(frameCount) / float64(totalFrames),
+			scroll:     renderer.CursorScroll(code.Tokens, charPos),
+		})
+		frameCount++
+	}
+	typingEnd := len(frames)
 
-		frame, err := renderer.RenderFrame(code.Tokens, charPos, showCursor, progress)
-		if err != nil {
-			return nil, fmt.Errorf("failed to render frame: %w", err)
+	// Work out where the camera glides to while holding the final frame
+	endScroll := renderer.CursorScroll(code.Tokens, totalChars)
+	targetScroll := endScroll
+	switch config.EndScroll {
+	case EndScrollTop:
+		targetScroll = 0
+	case EndScrollHighlight:
+		if len(config.HighlightLines) > 0 {
+			targetScroll = renderer.LineScroll(code.Tokens, middleLine(config.HighlightLines))
 		}
-
-		frames = append(frames, frame)
-		frameCount++
 	}
+	scrollFrames := finalFrameCount / 2
+
+	// The background settles where typing left it, so the held frames are
+	// identical and the encoder can merge them into one long frame
+	progress := float64(frameCount-1) / float64(totalFrames)
 
-	// Add final frames (hold for 2 seconds with no cursor)
+	// Hold the finished code with no cursor
+	holdStart := len(frames)
 	for i := 0; i < finalFrameCount; i++ {
-		progress := float64(frameCount) / float64(totalFrames)
-		frame, err := renderer.RenderFrame(code.Tokens, totalChars, false, progress)
-		if err != nil {
-			return nil, fmt.Errorf("failed to render final frame: %w", err)
+		scroll := targetScroll
+		if i < scrollFrames {
+			t := render.EaseInOut(float64(i) / float64(scrollFrames))
+			scroll = endScroll + (targetScroll-endScroll)*t
 		}
-		frames = append(frames, frame)
-		frameCount++
+		frames = append(frames, frame{
+			cursorPos: totalChars,
+			progress:  progress,
+			scroll:    scroll,
+		})
+	}
+
+	// Boomerang mode erases the code again by playing the reveal backwards,
+	// first gliding the camera back to where typing ended. It finishes on
+	// the empty editor, so a looping GIF runs straight into the start hold.
+	if config.Boomerang {
+		if targetScroll != endScroll {
+			frames = append(frames, reversed(frames[holdStart:holdStart+scrollFrames])...)
+		}
+		frames = append(frames, reversed(frames[typingStart:typingEnd-1])...)
+	}
+
+	jobs := config.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+
+	return &Animation{
+		renderer: renderer,
+		tokens:   code.Tokens,
+		style:    code.Style,
+		frames:   frames,
+		poster: frame{
+			cursorPos: totalChars,
+			progress:  progress,
+			scroll:    targetScroll,
+		},
+		fps:  config.FPS,
+		jobs: jobs,
+	}, nil
+}
+
+// NewPlayback plans an animation that replays a terminal recording in the
+// window, one screen per frame. The canvas fits the recording's terminal
+// size, and the hold and boomerang settings of config apply; the typing
+// settings do not.
+func NewPlayback(rec *cast.Recording, style *chroma.Style, config Config) (*Animation, error) {
+	// A terminal has no language to show behind it, and its rows must
+	// never wrap or gain numbers
+	config.Language = ""
+	config.LineNumbers = false
+	config.Wrap = render.WrapClip
+
+	renderer, err := newRenderer(config, style)
+	if err != nil {
+		return nil, err
+	}
+	renderer.FitColumns(rec.Header.Width)
+	blank := strings.TrimSuffix(strings.Repeat(" \n", rec.Header.Height), "\n")
+	if err := renderer.FitContent([]highlight.Token{{Text: blank}}); err != nil {
+		return nil, err
+	}
+
+	fg, bg := render.WindowColors(style)
+	played := int(math.Ceil(rec.Duration()*float64(config.FPS))) + 1
+	screens := rec.Screens(config.FPS, played, fg, bg)
+	holdStartFrames := durationFrames(config.HoldStart, config.FPS)
+	holdEndFrames := durationFrames(config.HoldEnd, config.FPS)
+	total := holdStartFrames + played + holdEndFrames
+
+	screen := func(tokens []highlight.Token, i int) frame {
+		return frame{
+			cursorPos: runeCount(tokens),
+			progress:  float64(i) / float64(total),
+			tokens:    tokens,
+		}
+	}
+	frames := make([]frame, 0, total)
+	for i := 0; i < holdStartFrames; i++ {
+		frames = append(frames, screen(screens[0], len(frames)))
+	}
+	start := len(frames)
+	for _, tokens := range screens {
+		frames = append(frames, screen(tokens, len(frames)))
+	}
+	end := len(frames)
+	last := frames[end-1]
+	for i := 0; i < holdEndFrames; i++ {
+		frames = append(frames, last)
+	}
+	if config.Boomerang {
+		frames = append(frames, reversed(frames[start:end-1])...)
 	}
 
-	return frames, nil
+	jobs := config.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+
+	return &Animation{
+		renderer: renderer,
+		tokens:   last.tokens,
+		style:    style,
+		frames:   frames,
+		poster:   last,
+		fps:      config.FPS,
+		jobs:     jobs,
+	}, nil
+}
+
+// newRenderer creates the renderer for config, drawing with style
+func newRenderer(config Config, style *chroma.Style) (*render.Renderer, error) {
+	// Create renderer with highlight config and visual enhancements
+	renderer, err := render.NewRenderer(render.Options{
+		Width:          config.Width,
+		FontSize:       config.FontSize,
+		HighlightLines: config.HighlightLines,
+		WindowStyle:    config.WindowStyle,
+		Theme:          config.Theme,
+		HiDPI:          config.HiDPI,
+		LineNumbers:    config.LineNumbers,
+		Language:       config.Language,
+		LaserReveal:    config.LaserReveal,
+		MinHeight:      config.MinHeight,
+		MaxHeight:      config.MaxHeight,
+		Overflow:       config.Overflow,
+		Wrap:           config.Wrap,
+		Font:           config.Font,
+		FontDir:        config.FontDir,
+		Style:          style,
+		Background:     config.Background,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create renderer: %w", err)
+	}
+	return renderer, nil
+}
+
+// Len returns the number of frames in the animation
+func (a *Animation) Len() int {
+	return len(a.frames)
+}
+
+// Render renders frame i of the animation
+func (a *Animation) Render(i int) (*image.RGBA, error) {
+	return a.render(a.renderer, i)
+}
+
+// Poster renders a still of the finished code: fully revealed, without a
+// cursor and with the camera where the end hold settles. Unlike the last
+// frame it shows the code even in boomerang mode or without an end hold.
+func (a *Animation) Poster() (*image.RGBA, error) {
+	img, err := a.draw(a.renderer, a.poster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render poster: %w", err)
+	}
+	return img, nil
+}
+
+// WriteSVG writes the animation as an animated SVG that keeps the code as
+// text, played plays times (0 = forever). Rather than rendering frames it
+// hands the frame plan to the renderer, which animates the reveal.
+func (a *Animation) WriteSVG(w io.Writer, plays int) error {
+	frames := make([]render.SVGFrame, len(a.frames))
+	for i, f := range a.frames {
+		frames[i] = f.svg()
+	}
+	return a.renderer.WriteSVG(w, a.tokens, render.SVGAnimation{
+		Frames: frames,
+		Poster: a.poster.svg(),
+		FPS:    a.fps,
+		Plays:  plays,
+	})
+}
+
+// WriteCast writes the typing as an asciicast v2 recording for terminal
+// players, on the same schedule as the frames: the characters each frame
+// reveals are output when it would be shown, in the theme's colours.
+func (a *Animation) WriteCast(w io.Writer, title string) error {
+	fg, bg := render.WindowColors(a.style)
+	typer := cast.NewTyper(a.tokens, bg)
+	cols, rows := typer.Size()
+	out, err := cast.NewWriter(w, cast.Header{
+		Width:  cols,
+		Height: rows,
+		Title:  title,
+		Env:    map[string]string{"TERM": "xterm-256color"},
+		Theme:  cast.NewTheme(fg, bg),
+	})
+	if err != nil {
+		return err
+	}
+
+	cursor := true // Terminals start with the cursor shown
+	for i, f := range a.frames {
+		data := typer.Show(f.cursorPos)
+		if f.showCursor != cursor {
+			cursor = f.showCursor
+			if cursor {
+				data += cast.ShowCursor
+			} else {
+				data += cast.HideCursor
+			}
+		}
+		if data == "" {
+			continue
+		}
+		if err := out.Output(float64(i)/float64(a.fps), data); err != nil {
+			return err
+		}
+	}
+
+	// An empty event at the end keeps the last frame on screen for its
+	// full time
+	return out.Output(float64(len(a.frames))/float64(a.fps), "")
+}
+
+// svg returns the frame as the renderer's SVG writer takes it
+func (f frame) svg() render.SVGFrame {
+	return render.SVGFrame{
+		CursorPos:  f.cursorPos,
+		ShowCursor: f.showCursor,
+		Progress:   f.progress,
+		Scroll:     f.scroll,
+	}
+}
+
+func (a *Animation) render(r *render.Renderer, i int) (*image.RGBA, error) {
+	img, err := a.draw(r, a.frames[i])
+	if err != nil {
+		return nil, fmt.Errorf("failed to render frame %d: %w", i, err)
+	}
+	return img, nil
+}
+
+// draw renders a planned frame with r
+func (a *Animation) draw(r *render.Renderer, f frame) (*image.RGBA, error) {
+	tokens := a.tokens
+	if f.tokens != nil {
+		tokens = f.tokens
+	}
+	return r.RenderFrameScrolled(tokens, f.cursorPos, f.showCursor, f.progress, f.scroll)
+}
+
+// rendered is the outcome of rendering one frame on a worker
+type rendered struct {
+	img *image.RGBA
+	err error
+}
+
+// Frames renders the frames in order, stopping at the first error. Frames
+// are rendered by a pool of workers, each with its own renderer, a few
+// frames ahead of the consumer; the lookahead is bounded so memory stays
+// flat however long the animation is.
+func (a *Animation) Frames() iter.Seq2[*image.RGBA, error] {
+	return func(yield func(*image.RGBA, error) bool) {
+		if a.jobs <= 1 {
+			for i := range a.frames {
+				img, err := a.Render(i)
+				if !yield(img, err) || err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		type job struct {
+			index  int
+			result chan rendered
+		}
+		jobs := make(chan job)
+		// Results are queued in frame order; the buffer is the lookahead
+		order := make(chan chan rendered, 2*a.jobs)
+		done := make(chan struct{})
+
+		var wg sync.WaitGroup
+		for w := 0; w < a.jobs; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := a.renderer.Clone()
+				for j := range jobs {
+					img, err := a.render(r, j.index)
+					j.result <- rendered{img, err}
+				}
+			}()
+		}
+
+		go func() {
+			defer close(order)
+			defer close(jobs)
+			for i := range a.frames {
+				result := make(chan rendered, 1)
+				select {
+				case order <- result:
+				case <-done:
+					return
+				}
+				select {
+				case jobs <- job{i, result}:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for result := range order {
+			r := <-result
+			if !yield(r.img, r.err) || r.err != nil {
+				break
+			}
+		}
+
+		// On an early stop the feeder sees done and closes jobs, which
+		// lets the workers finish
+		close(done)
+		wg.Wait()
+	}
+}
+
+// durationFrames converts a duration to a whole number of frames at fps
+func durationFrames(d time.Duration, fps int) int {
+	return int(math.Round(d.Seconds() * float64(fps)))
+}
+
+// runeCount counts the runes of tokens
+func runeCount(tokens []highlight.Token) int {
+	n := 0
+	for _, token := range tokens {
+		n += len([]rune(token.Text))
+	}
+	return n
+}
+
+// reversed returns a copy of frames in reverse order
+func reversed(frames []frame) []frame {
+	out := make([]frame, len(frames))
+	for i, frame := range frames {
+		out[len(frames)-1-i] = frame
+	}
+	return out
+}
+
+// middleLine returns the line halfway between the first and last of lines
+func middleLine(lines []int) int {
+	lo, hi := lines[0], lines[0]
+	for _, line := range lines {
+		lo = min(lo, line)
+		hi = max(hi, line)
+	}
+	return (lo + hi) / 2
+}
+
+// CharsPerFrame returns how many characters each frame types at speed
+func CharsPerFrame(speed float64) int {
+	return int(math.Max(1, 2*speed))
 }
@@ -0,0 +1,164 @@
+package cast
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// Escape sequences for the cursor and screen
+const (
+	ShowCursor  = "\x1b[?25h"
+	HideCursor  = "\x1b[?25l"
+	clearScreen = "\x1b[H\x1b[2J"
+	resetSGR    = "\x1b[0m"
+)
+
+// tabStop matches the renderer's tab width rather than a terminal's 8
+const tabStop = 4
+
+// palette is the 16 ANSI colours, as asciinema's default theme draws them
+var palette = [16]chroma.Colour{
+	chroma.MustParseColour("#000000"), chroma.MustParseColour("#dd3c69"),
+	chroma.MustParseColour("#4ebf22"), chroma.MustParseColour("#ddaf3c"),
+	chroma.MustParseColour("#26b0d7"), chroma.MustParseColour("#b954e1"),
+	chroma.MustParseColour("#54e1b9"), chroma.MustParseColour("#d9d9d9"),
+	chroma.MustParseColour("#4d4d4d"), chroma.MustParseColour("#dd3c69"),
+	chroma.MustParseColour("#4ebf22"), chroma.MustParseColour("#ddaf3c"),
+	chroma.MustParseColour("#26b0d7"), chroma.MustParseColour("#b954e1"),
+	chroma.MustParseColour("#54e1b9"), chroma.MustParseColour("#ffffff"),
+}
+
+// NewTheme returns a header theme with the given default colours and the
+// ANSI palette
+func NewTheme(fg, bg chroma.Colour) *Theme {
+	colours := make([]string, len(palette))
+	for i, c := range palette {
+		colours[i] = c.String()
+	}
+	return &Theme{Fg: fg.String(), Bg: bg.String(), Palette: strings.Join(colours, ":")}
+}
+
+// Typer turns the reveal of highlighted tokens into terminal output. It
+// tracks how much of the code the terminal shows, so each step only
+// writes what changed.
+type Typer struct {
+	runes      []rune
+	styles     []chroma.StyleEntry
+	background chroma.Colour // The theme's own background, never painted
+	shown      int           // Runes on screen
+	col        int           // Column of the terminal cursor
+	sgr        string        // Attributes the terminal draws with
+}
+
+// NewTyper creates a typer for tokens, starting from an empty screen.
+// Token backgrounds equal to background are left to the terminal.
+func NewTyper(tokens []highlight.Token, background chroma.Colour) *Typer {
+	t := &Typer{background: background, sgr: resetSGR}
+	for _, token := range tokens {
+		for _, ch := range token.Text {
+			t.runes = append(t.runes, ch)
+			t.styles = append(t.styles, token.Style)
+		}
+	}
+	return t
+}
+
+// Size returns the columns and rows the fully revealed code needs. A
+// spare column and the row after a trailing newline leave room for the
+// cursor, so the screen never wraps or scrolls.
+func (t *Typer) Size() (cols, rows int) {
+	col, rows := 0, 1
+	for _, ch := range t.runes {
+		switch ch {
+		case '\n':
+			rows++
+			col = 0
+		case '\t':
+			col += tabStop - col%tabStop
+		default:
+			col += runeWidth(ch)
+		}
+		cols = max(cols, col)
+	}
+	return cols + 1, rows
+}
+
+// Show returns the output that takes the terminal from showing the first
+// runes it shows now to showing the first n. Going back within a line
+// rubs the runes out; across lines it clears the screen and types the
+// shorter prefix again.
+func (t *Typer) Show(n int) string {
+	n = max(0, min(n, len(t.runes)))
+	var out strings.Builder
+	if n < t.shown {
+		t.setSGR(&out, resetSGR)
+		if erased := t.runes[n:t.shown]; !slices.Contains(erased, '\n') && !slices.Contains(erased, '\t') {
+			cells := 0
+			for _, ch := range erased {
+				cells += runeWidth(ch)
+			}
+			back := strings.Repeat("\b", cells)
+			out.WriteString(back + strings.Repeat(" ", cells) + back)
+			t.shown, t.col = n, t.col-cells
+			return out.String()
+		}
+		out.WriteString(clearScreen)
+		t.shown, t.col = 0, 0
+	}
+
+	for ; t.shown < n; t.shown++ {
+		ch := t.runes[t.shown]
+		if ch == '\n' {
+			// Reset first so a background does not bleed into the next row
+			t.setSGR(&out, resetSGR)
+			out.WriteString("\r\n")
+			t.col = 0
+			continue
+		}
+		t.setSGR(&out, t.sgrFor(t.styles[t.shown]))
+		if ch == '\t' {
+			w := tabStop - t.col%tabStop
+			out.WriteString(strings.Repeat(" ", w))
+			t.col += w
+			continue
+		}
+		out.WriteRune(ch)
+		t.col += runeWidth(ch)
+	}
+	return out.String()
+}
+
+// setSGR switches the terminal to the attributes sgr if it is not using
+// them already
+func (t *Typer) setSGR(out *strings.Builder, sgr string) {
+	if sgr != t.sgr {
+		out.WriteString(sgr)
+		t.sgr = sgr
+	}
+}
+
+// sgrFor returns the escape sequence that draws with a token style in
+// 24-bit colour
+func (t *Typer) sgrFor(style chroma.StyleEntry) string {
+	params := "0"
+	if style.Bold == chroma.Yes {
+		params += ";1"
+	}
+	if style.Italic == chroma.Yes {
+		params += ";3"
+	}
+	if style.Underline == chroma.Yes {
+		params += ";4"
+	}
+	if c := style.Colour; c.IsSet() {
+		params += fmt.Sprintf(";38;2;%d;%d;%d", c.Red(), c.Green(), c.Blue())
+	}
+	if c := style.Background; c.IsSet() && c != t.background {
+		params += fmt.Sprintf(";48;2;%d;%d;%d", c.Red(), c.Green(), c.Blue())
+	}
+	return "\x1b[" + params + "m"
+}
@@ -0,0 +1,57 @@
+package cast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+func TestTyperSize(t *testing.T) {
+	tests := []struct {
+		code       string
+		cols, rows int
+	}{
+		{"", 1, 1},
+		{"abc", 4, 1},
+		{"ab\ncdef\n", 5, 3},
+		{"\tx", tabStop + 2, 1},
+		{"日本語 wide", 12, 1},
+	}
+	for _, tt := range tests {
+		typer := NewTyper([]highlight.Token{{Text: tt.code}}, 0)
+		if cols, rows := typer.Size(); cols != tt.cols || rows != tt.rows {
+			t.Errorf("Size() of %q = %dx%d, want %dx%d", tt.code, cols, rows, tt.cols, tt.rows)
+		}
+	}
+}
+
+// TestTyperShow replays the typer's output through a Terminal, moving
+// forwards and back, and checks the screen shows exactly the revealed
+// code each time
+func TestTyperShow(t *testing.T) {
+	red := chroma.MustParseColour("#ff0000")
+	tokens := []highlight.Token{
+		{Text: "func", Style: chroma.StyleEntry{Colour: red, Bold: chroma.Yes}},
+		{Text: " 日本(x)\n\tif x {\n"},
+		{Text: "wide 語", Style: chroma.StyleEntry{Background: red}},
+	}
+	code := []rune("func 日本(x)\n\tif x {\nwide 語")
+
+	typer := NewTyper(tokens, 0)
+	cols, rows := typer.Size()
+	term := NewTerminal(cols, rows, 0, 0)
+	for _, n := range []int{3, 8, 6, 15, 12, 99, 20, 0, 22} {
+		term.Write(typer.Show(n))
+		// The screen has no trailing blanks, so neither does what it shows
+		lines := strings.Split(string(code[:min(n, len(code))]), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabStop)), " ")
+		}
+		want := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+		if got := screen(term); got != want {
+			t.Errorf("Show(%d): screen %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package cast reads and writes asciinema recordings in the asciicast v2
+// format: a JSON header line followed by one JSON array per event.
+package cast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// Extension is the file extension of asciicast recordings
+const Extension = ".cast"
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	Theme         *Theme            `json:"theme,omitempty"`
+}
+
+// Theme is the terminal colour scheme a recording asks players to use.
+// Colours are #rrggbb; Palette lists 8 or 16 of them separated by colons.
+type Theme struct {
+	Fg      string `json:"fg"`
+	Bg      string `json:"bg"`
+	Palette string `json:"palette"`
+}
+
+// Event is one entry of a recording. Type "o" is terminal output; the
+// others (input, markers, resizes) are kept but not played.
+type Event struct {
+	Time float64 // Seconds since the start of the recording
+	Type string
+	Data string
+}
+
+// MarshalJSON encodes an event as asciicast's [time, type, data] array
+func (e Event) MarshalJSON() ([]byte, error) {
+	// Microsecond precision, as asciinema writes
+	return json.Marshal([]any{math.Round(e.Time*1e6) / 1e6, e.Type, e.Data})
+}
+
+// UnmarshalJSON decodes a [time, type, data] array
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("event has %d fields, want 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return fmt.Errorf("event time: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return fmt.Errorf("event type: %w", err)
+	}
+	if err := json.Unmarshal(fields[2], &e.Data); err != nil {
+		return fmt.Errorf("event data: %w", err)
+	}
+	return nil
+}
+
+// Writer writes a recording one event at a time
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter writes the header to w and returns a writer for the events
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Version = 2
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return &Writer{enc: enc}, nil
+}
+
+// Output writes terminal output shown t seconds into the recording
+func (w *Writer) Output(t float64, data string) error {
+	return w.enc.Encode(Event{Time: t, Type: "o", Data: data})
+}
+
+// Recording is a parsed asciicast v2 file
+type Recording struct {
+	Header Header
+	Events []Event
+}
+
+// IsRecording reports whether a path names an asciicast file
+func IsRecording(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), Extension)
+}
+
+// Read parses an asciicast v2 recording
+func Read(r io.Reader) (*Recording, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var rec Recording
+	line, header := 0, false
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if !header {
+			header = true
+			if err := json.Unmarshal([]byte(text), &rec.Header); err != nil {
+				return nil, fmt.Errorf("invalid asciicast header: %w", err)
+			}
+			if rec.Header.Version != 2 {
+				return nil, fmt.Errorf("unsupported asciicast version %d (want 2)", rec.Header.Version)
+			}
+			if rec.Header.Width <= 0 || rec.Header.Height <= 0 {
+				return nil, fmt.Errorf("invalid terminal size %dx%d", rec.Header.Width, rec.Header.Height)
+			}
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(text), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rec.Events = append(rec.Events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("empty asciicast file")
+	}
+	return &rec, nil
+}
+
+// Duration is the time of the last event, after the idle time limit
+func (r *Recording) Duration() float64 {
+	times := r.times()
+	if len(times) == 0 {
+		return 0
+	}
+	return times[len(times)-1]
+}
+
+// times returns when each event plays, with pauses longer than the
+// header's idle time limit shortened to it, as asciinema players do
+func (r *Recording) times() []float64 {
+	times := make([]float64, len(r.Events))
+	limit := r.Header.IdleTimeLimit
+	prev, shift := 0.0, 0.0
+	for i, e := range r.Events {
+		if gap := e.Time - prev; limit > 0 && gap > limit {
+			shift += gap - limit
+		}
+		prev = e.Time
+		times[i] = e.Time - shift
+	}
+	return times
+}
+
+// Screens plays the recording's output into a terminal and returns the
+// screen in each of frames frames, taken fps times a second. Unchanged
+// screens share one token slice. fg and bg are the terminal's default
+// colours.
+func (r *Recording) Screens(fps, frames int, fg, bg chroma.Colour) [][]highlight.Token {
+	term := NewTerminal(r.Header.Width, r.Header.Height, fg, bg)
+	times := r.times()
+	screens := make([][]highlight.Token, frames)
+
+	next := 0
+	var screen []highlight.Token
+	for i := range screens {
+		t := float64(i) / float64(fps)
+		changed := screen == nil
+		for ; next < len(r.Events) && times[next] <= t; next++ {
+			if r.Events[next].Type == "o" {
+				term.Write(r.Events[next].Data)
+				changed = true
+			}
+		}
+		if changed {
+			screen = term.Tokens()
+		}
+		screens[i] = screen
+	}
+	return screens
+}
@@ -0,0 +1,555 @@
+package cast
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// attr is the drawing attributes of a terminal cell. Unset colours are
+// the terminal's defaults.
+type attr struct {
+	fg, bg                           chroma.Colour
+	bold, italic, underline, reverse bool
+}
+
+// cell is one character position of the screen. The right half of a
+// wide character is a cell with ch 0.
+type cell struct {
+	ch rune
+	attr
+}
+
+// parser states
+const (
+	stateGround = iota
+	stateEscape
+	stateCSI
+	stateOSC
+	stateOSCEscape
+	stateCharset
+)
+
+// Terminal is a small VT100/xterm emulator, enough to replay shell
+// sessions: printing with wide characters, cursor movement, erasing,
+// scroll regions, the alternate screen and SGR colours. Other sequences
+// are parsed and ignored.
+type Terminal struct {
+	cols, rows int
+	cells      [][]cell
+	main       [][]cell // The main screen while the alternate one is shown
+	x, y       int
+	wrapNext   bool // The last column was written; the next rune wraps
+	saveX      int
+	saveY      int
+	top        int // First row of the scroll region
+	bottom     int // Last row of the scroll region
+	pen        attr
+	fg, bg     chroma.Colour // Defaults, used to draw reverse video
+
+	state  int
+	params []byte
+}
+
+// NewTerminal creates an empty cols×rows terminal whose default colours
+// are fg and bg
+func NewTerminal(cols, rows int, fg, bg chroma.Colour) *Terminal {
+	t := &Terminal{cols: cols, rows: rows, bottom: rows - 1, fg: fg, bg: bg}
+	t.cells = t.blankScreen()
+	return t
+}
+
+// Write feeds output to the terminal. Escape sequences may be split
+// across writes.
+func (t *Terminal) Write(data string) {
+	for _, ch := range data {
+		switch t.state {
+		case stateGround:
+			t.ground(ch)
+		case stateEscape:
+			t.escape(ch)
+		case stateCSI:
+			if ch >= 0x40 && ch <= 0x7e {
+				t.csi(ch, string(t.params))
+				t.state = stateGround
+			} else {
+				t.params = append(t.params, byte(ch))
+			}
+		case stateOSC:
+			// Titles and the like end with BEL or ST
+			switch ch {
+			case '\a':
+				t.state = stateGround
+			case 0x1b:
+				t.state = stateOSCEscape
+			}
+		case stateOSCEscape:
+			t.state = stateGround
+		case stateCharset:
+			t.state = stateGround
+		}
+	}
+}
+
+func (t *Terminal) ground(ch rune) {
+	switch ch {
+	case 0x1b:
+		t.state = stateEscape
+	case '\r':
+		t.x, t.wrapNext = 0, false
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\b':
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapNext = false
+	case '\t':
+		t.x = min(t.cols-1, (t.x/8+1)*8)
+	default:
+		if ch < 0x20 || ch == 0x7f {
+			return
+		}
+		w := runeWidth(ch)
+		if w == 0 {
+			// Combining marks and joiners have no cell of their own
+			t.combine(ch)
+			return
+		}
+		// A wide character that does not fit wraps whole
+		if t.wrapNext || t.x+w > t.cols {
+			t.x = 0
+			t.lineFeed()
+		}
+		t.put(t.x, cell{ch: ch, attr: t.pen})
+		if w == 2 {
+			t.put(t.x+1, cell{attr: t.pen})
+		}
+		if t.x+w == t.cols {
+			t.x, t.wrapNext = t.cols-1, true
+		} else {
+			t.x += w
+		}
+	}
+}
+
+// put writes a cell of the current row, blanking what is left of a wide
+// character it overwrites half of
+func (t *Terminal) put(x int, c cell) {
+	row := t.cells[t.y]
+	if row[x].ch == 0 && x > 0 {
+		row[x-1].ch = ' '
+	}
+	if x+1 < t.cols && row[x+1].ch == 0 && c.ch != 0 {
+		row[x+1].ch = ' '
+	}
+	row[x] = c
+}
+
+// combine joins a combining mark to the character last written, when
+// Unicode has a precomposed form of the pair. Other zero-width runes are
+// dropped.
+func (t *Terminal) combine(mark rune) {
+	x := t.x - 1
+	if t.wrapNext {
+		x = t.x
+	}
+	if x > 0 && t.cells[t.y][x].ch == 0 {
+		x--
+	}
+	if x < 0 {
+		return
+	}
+	c := &t.cells[t.y][x]
+	if composed := []rune(norm.NFC.String(string([]rune{c.ch, mark}))); len(composed) == 1 {
+		c.ch = composed[0]
+	}
+}
+
+// runeWidth returns how many cells ch takes: 2 for East Asian wide
+// characters and most emoji, 0 for combining marks and other zero-width
+// runes, otherwise 1
+func runeWidth(ch rune) int {
+	if unicode.In(ch, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	switch width.LookupRune(ch).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+func (t *Terminal) escape(ch rune) {
+	t.state = stateGround
+	switch ch {
+	case '[':
+		t.state, t.params = stateCSI, t.params[:0]
+	case ']':
+		t.state = stateOSC
+	case '(', ')', '*', '+':
+		t.state = stateCharset
+	case '7':
+		t.saveX, t.saveY = t.x, t.y
+	case '8':
+		t.x, t.y, t.wrapNext = t.saveX, t.saveY, false
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.x = 0
+		t.lineFeed()
+	case 'M':
+		if t.y == t.top {
+			t.insertLines(t.top, 1)
+		} else if t.y > 0 {
+			t.y--
+		}
+	case 'c':
+		*t = *NewTerminal(t.cols, t.rows, t.fg, t.bg)
+	}
+}
+
+// csi runs a control sequence with its parameter bytes
+func (t *Terminal) csi(final rune, params string) {
+	// Private modes such as ?25h only change what we do not draw, except
+	// the alternate screen
+	if strings.HasPrefix(params, "?") {
+		if final == 'h' || final == 'l' {
+			for _, mode := range parseParams(params[1:]) {
+				if mode == 1049 {
+					t.alternateScreen(final == 'h')
+				}
+			}
+		}
+		return
+	}
+
+	args := parseParams(params)
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	t.wrapNext = false
+	switch final {
+	case 'A':
+		t.y = max(0, t.y-arg(0, 1))
+	case 'B', 'e':
+		t.y = min(t.rows-1, t.y+arg(0, 1))
+	case 'C', 'a':
+		t.x = min(t.cols-1, t.x+arg(0, 1))
+	case 'D':
+		t.x = max(0, t.x-arg(0, 1))
+	case 'E':
+		t.x, t.y = 0, min(t.rows-1, t.y+arg(0, 1))
+	case 'F':
+		t.x, t.y = 0, max(0, t.y-arg(0, 1))
+	case 'G', '`':
+		t.x = min(t.cols, arg(0, 1)) - 1
+	case 'd':
+		t.y = min(t.rows, arg(0, 1)) - 1
+	case 'H', 'f':
+		t.y = min(t.rows, arg(0, 1)) - 1
+		t.x = min(t.cols, arg(1, 1)) - 1
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			t.erase(t.x, t.y, t.cols, t.y+1)
+			t.erase(0, t.y+1, t.cols, t.rows)
+		case 1:
+			t.erase(0, 0, t.cols, t.y)
+			t.erase(0, t.y, t.x+1, t.y+1)
+		default:
+			t.erase(0, 0, t.cols, t.rows)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			t.erase(t.x, t.y, t.cols, t.y+1)
+		case 1:
+			t.erase(0, t.y, t.x+1, t.y+1)
+		default:
+			t.erase(0, t.y, t.cols, t.y+1)
+		}
+	case 'X':
+		t.erase(t.x, t.y, min(t.cols, t.x+arg(0, 1)), t.y+1)
+	case 'P':
+		row := t.cells[t.y]
+		n := min(arg(0, 1), t.cols-t.x)
+		copy(row[t.x:], row[t.x+n:])
+		t.erase(t.cols-n, t.y, t.cols, t.y+1)
+	case '@':
+		row := t.cells[t.y]
+		n := min(arg(0, 1), t.cols-t.x)
+		copy(row[t.x+n:], row[t.x:])
+		t.erase(t.x, t.y, t.x+n, t.y+1)
+	case 'L':
+		if t.y >= t.top && t.y <= t.bottom {
+			t.insertLines(t.y, arg(0, 1))
+		}
+	case 'M':
+		if t.y >= t.top && t.y <= t.bottom {
+			t.deleteLines(t.y, arg(0, 1))
+		}
+	case 'S':
+		t.deleteLines(t.top, arg(0, 1))
+	case 'T':
+		t.insertLines(t.top, arg(0, 1))
+	case 'r':
+		// Regions of fewer than two rows are ignored, as xterm does
+		top, bottom := arg(0, 1)-1, min(arg(1, t.rows), t.rows)-1
+		if top < bottom {
+			t.top, t.bottom = top, bottom
+			t.x, t.y = 0, 0
+		}
+	case 's':
+		t.saveX, t.saveY = t.x, t.y
+	case 'u':
+		t.x, t.y = t.saveX, t.saveY
+	case 'm':
+		t.sgr(args)
+	}
+}
+
+// sgr applies Select Graphic Rendition parameters to the pen
+func (t *Terminal) sgr(args []int) {
+	if len(args) == 0 {
+		args = []int{0}
+	}
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == 0:
+			t.pen = attr{}
+		case a == 1:
+			t.pen.bold = true
+		case a == 3:
+			t.pen.italic = true
+		case a == 4:
+			t.pen.underline = true
+		case a == 7:
+			t.pen.reverse = true
+		case a == 22:
+			t.pen.bold = false
+		case a == 23:
+			t.pen.italic = false
+		case a == 24:
+			t.pen.underline = false
+		case a == 27:
+			t.pen.reverse = false
+		case a >= 30 && a <= 37:
+			t.pen.fg = palette[a-30]
+		case a == 39:
+			t.pen.fg = 0
+		case a >= 40 && a <= 47:
+			t.pen.bg = palette[a-40]
+		case a == 49:
+			t.pen.bg = 0
+		case a >= 90 && a <= 97:
+			t.pen.fg = palette[a-90+8]
+		case a >= 100 && a <= 107:
+			t.pen.bg = palette[a-100+8]
+		case a == 38 || a == 48:
+			c, n := extendedColour(args[i+1:])
+			i += n
+			if a == 38 {
+				t.pen.fg = c
+			} else {
+				t.pen.bg = c
+			}
+		}
+	}
+}
+
+// extendedColour decodes the 256-colour (5;n) or 24-bit (2;r;g;b) form
+// following SGR 38 or 48, returning the colour and the parameters used
+func extendedColour(args []int) (chroma.Colour, int) {
+	if len(args) >= 2 && args[0] == 5 {
+		return colour256(args[1]), 2
+	}
+	if len(args) >= 4 && args[0] == 2 {
+		return chroma.NewColour(uint8(args[1]), uint8(args[2]), uint8(args[3])), 4
+	}
+	return 0, len(args)
+}
+
+// colour256 returns an entry of the xterm 256-colour palette
+func colour256(n int) chroma.Colour {
+	switch {
+	case n < 16:
+		return palette[max(n, 0)]
+	case n < 232:
+		n -= 16
+		level := func(v int) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return uint8(55 + v*40)
+		}
+		return chroma.NewColour(level(n/36), level(n/6%6), level(n%6))
+	case n < 256:
+		v := uint8(8 + (n-232)*10)
+		return chroma.NewColour(v, v, v)
+	}
+	return 0
+}
+
+// parseParams splits CSI parameters such as "1;31" into numbers, with
+// missing ones as 0
+func parseParams(params string) []int {
+	if params == "" {
+		return nil
+	}
+	fields := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	args := make([]int, len(fields))
+	for i, f := range fields {
+		args[i], _ = strconv.Atoi(f)
+	}
+	return args
+}
+
+// lineFeed moves the cursor down a row, scrolling the scroll region when
+// it is on the region's last row
+func (t *Terminal) lineFeed() {
+	t.wrapNext = false
+	switch {
+	case t.y == t.bottom:
+		t.deleteLines(t.top, 1)
+	case t.y < t.rows-1:
+		t.y++
+	}
+}
+
+// insertLines pushes the rows of the scroll region from y down by n,
+// dropping those that fall off its bottom
+func (t *Terminal) insertLines(y, n int) {
+	end := t.bottom + 1
+	n = min(n, end-y)
+	copy(t.cells[y+n:end], t.cells[y:end-n])
+	for i := y; i < y+n; i++ {
+		t.cells[i] = t.blankRow()
+	}
+}
+
+// deleteLines removes n rows of the scroll region from y, moving the rest
+// of the region up
+func (t *Terminal) deleteLines(y, n int) {
+	end := t.bottom + 1
+	n = min(n, end-y)
+	copy(t.cells[y:end], t.cells[y+n:end])
+	for i := end - n; i < end; i++ {
+		t.cells[i] = t.blankRow()
+	}
+}
+
+// alternateScreen switches to a blank alternate screen, saving the
+// cursor, or back to the main screen as it was left
+func (t *Terminal) alternateScreen(on bool) {
+	switch {
+	case on && t.main == nil:
+		t.saveX, t.saveY = t.x, t.y
+		t.main, t.cells = t.cells, t.blankScreen()
+	case !on && t.main != nil:
+		t.cells, t.main = t.main, nil
+		t.x, t.y, t.wrapNext = t.saveX, t.saveY, false
+	}
+}
+
+// erase blanks the cells in columns [x0, x1) of rows [y0, y1), keeping the
+// pen's background as terminals do
+func (t *Terminal) erase(x0, y0, x1, y1 int) {
+	blank := cell{ch: ' ', attr: attr{bg: t.pen.bg}}
+	for y := max(0, y0); y < min(y1, t.rows); y++ {
+		for x := max(0, x0); x < min(x1, t.cols); x++ {
+			t.cells[y][x] = blank
+		}
+	}
+}
+
+func (t *Terminal) blankScreen() [][]cell {
+	cells := make([][]cell, t.rows)
+	for y := range cells {
+		cells[y] = t.blankRow()
+	}
+	return cells
+}
+
+func (t *Terminal) blankRow() []cell {
+	row := make([]cell, t.cols)
+	for i := range row {
+		row[i].ch = ' '
+	}
+	return row
+}
+
+// Tokens returns the screen as highlighted tokens, one line per row with
+// trailing blanks trimmed. Runs of cells with the same attributes share
+// a token.
+func (t *Terminal) Tokens() []highlight.Token {
+	var tokens []highlight.Token
+	for y, row := range t.cells {
+		if y > 0 {
+			tokens = append(tokens, highlight.Token{Text: "\n"})
+		}
+
+		end := len(row)
+		for end > 0 && row[end-1].ch == ' ' && row[end-1].bg == 0 && !row[end-1].reverse {
+			end--
+		}
+		var text strings.Builder
+		style := chroma.StyleEntry{}
+		for x := 0; x < end; x++ {
+			ch := row[x].ch
+			if ch == 0 {
+				// The right half of a wide character, unless erasing or
+				// shifting cells split it from its left half
+				if x > 0 && runeWidth(row[x-1].ch) == 2 {
+					continue
+				}
+				ch = ' '
+			}
+			s := t.style(row[x].attr)
+			if x > 0 && s != style {
+				tokens = append(tokens, highlight.Token{Text: text.String(), Style: style})
+				text.Reset()
+			}
+			style = s
+			text.WriteRune(ch)
+		}
+		if text.Len() > 0 {
+			tokens = append(tokens, highlight.Token{Text: text.String(), Style: style})
+		}
+	}
+	return tokens
+}
+
+// style converts cell attributes to the style the renderer draws with
+func (t *Terminal) style(a attr) chroma.StyleEntry {
+	fg, bg := a.fg, a.bg
+	if a.reverse {
+		if !fg.IsSet() {
+			fg = t.fg
+		}
+		if !bg.IsSet() {
+			bg = t.bg
+		}
+		fg, bg = bg, fg
+	}
+	s := chroma.StyleEntry{Colour: fg, Background: bg}
+	if a.bold {
+		s.Bold = chroma.Yes
+	}
+	if a.italic {
+		s.Italic = chroma.Yes
+	}
+	if a.underline {
+		s.Underline = chroma.Yes
+	}
+	return s
+}
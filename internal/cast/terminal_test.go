@@ -0,0 +1,82 @@
+package cast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+// screen returns the terminal's rows as text, without the blank rows at
+// the bottom
+func screen(term *Terminal) string {
+	var text strings.Builder
+	for _, token := range term.Tokens() {
+		text.WriteString(token.Text)
+	}
+	return strings.TrimRight(text.String(), "\n")
+}
+
+func TestTerminal(t *testing.T) {
+	tests := []struct {
+		name       string
+		cols, rows int
+		input      string
+		want       string
+	}{
+		{"print", 10, 3, "hello\r\nworld", "hello\nworld"},
+		{"wrap", 4, 3, "abcdef", "abcd\nef"},
+		{"scroll", 5, 2, "1\r\n2\r\n3", "2\n3"},
+		{"erase line", 10, 2, "hello\x1b[3D\x1b[K", "he"},
+		{"wide characters", 12, 2, "日本語 wide", "日本語 wide"},
+		{"wide character wraps whole", 5, 2, "abcd日", "abcd\n日"},
+		{"overwritten half of a wide character", 5, 2, "日本\b\bx", "日x"},
+		{"combining mark", 5, 2, "e\u0301x", "\u00e9x"},
+		{"joiner", 5, 2, "a\u200dx", "ax"},
+		{"scroll region", 5, 4, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[3;1H\n", "1\n3\n\n4"},
+		{"reverse index at region top", 5, 4, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[2;1H\x1bM", "1\n\n2\n4"},
+		{"scroll up region", 5, 4, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[S", "1\n3\n\n4"},
+		{"insert lines in region", 5, 4, "1\r\n2\r\n3\r\n4\x1b[1;3r\x1b[1;1H\x1b[L", "\n1\n2\n4"},
+		{"region homes the cursor", 5, 3, "abc\x1b[2;3rx", "xbc"},
+		{"one-row region is ignored", 5, 3, "1\r\n2\r\n3\x1b[3;3r\n", "2\n3"},
+		{"alternate screen starts blank", 10, 2, "main\x1b[?1049halt", "    alt"},
+		{"alternate screen restores main", 10, 2, "main\x1b[?1049h\x1b[Halt\x1b[?1049l!", "main!"},
+		{"alternate screen among other modes", 10, 2, "main\x1b[?25;1049h\x1b[?1049l", "main"},
+		{"split escape", 10, 2, "a\x1b", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := NewTerminal(tt.cols, tt.rows, 0, 0)
+			term.Write(tt.input)
+			if got := screen(term); got != tt.want {
+				t.Errorf("screen %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalWideColumns(t *testing.T) {
+	term := NewTerminal(20, 2, 0, 0)
+	term.Write("日本語 wide")
+	if got := term.cells[0][7].ch; got != 'w' {
+		t.Errorf("column 7 holds %q, want 'w'", got)
+	}
+	if term.x != 11 {
+		t.Errorf("cursor at column %d, want 11", term.x)
+	}
+}
+
+func TestTerminalSGR(t *testing.T) {
+	term := NewTerminal(20, 1, 0, 0)
+	term.Write("\x1b[1;31mred\x1b[0m \x1b[38;5;21mblue")
+	tokens := term.Tokens()
+	if len(tokens) != 3 {
+		t.Fatalf("got %d tokens, want 3: %v", len(tokens), tokens)
+	}
+	if s := tokens[0].Style; tokens[0].Text != "red" || s.Colour != palette[1] || s.Bold != chroma.Yes {
+		t.Errorf("first token %q has style %v, want bold ANSI red", tokens[0].Text, s)
+	}
+	if s := tokens[2].Style; tokens[2].Text != "blue" || s.Colour != colour256(21) {
+		t.Errorf("last token %q has style %v, want colour 21", tokens[2].Text, s)
+	}
+}
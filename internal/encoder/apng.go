@@ -0,0 +1,298 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNG frame control values
+const (
+	apngDisposeNone = 0 // Leave the frame on the canvas
+	apngBlendSource = 0 // Replace the region, alpha included
+	apngBlendOver   = 1 // Alpha blend the frame onto the canvas
+)
+
+// APNGEncoder writes an animated PNG. Frames keep full 24-bit colour and
+// alpha. In delta mode every frame after the first covers only the
+// rectangle that changed, with unchanged pixels left transparent, and is
+// blended over the canvas.
+//
+// The frame count is stored in the header, so it is patched in on Close;
+// frames are still written as they arrive.
+type APNGEncoder struct {
+	opts     Options
+	w        io.WriteSeeker
+	base     int64 // Offset of the file in w
+	bw       *bufio.Writer
+	out      *countingWriter
+	timeline timeline[*image.RGBA]
+	last     *image.RGBA
+	delta    *image.RGBA // Buffer for delta frames
+	seq      uint32      // Next sequence number for fcTL and fdAT chunks
+	actl     int64       // Offset of the acTL chunk data in the file
+
+	zbuf  bytes.Buffer
+	zw    *zlib.Writer
+	stats Stats
+}
+
+// NewAPNGEncoder creates an encoder writing an animated PNG to w
+func NewAPNGEncoder(w io.WriteSeeker, opts Options) (*APNGEncoder, error) {
+	base, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	e := &APNGEncoder{
+		opts: opts,
+		w:    w,
+		base: base,
+		bw:   bufio.NewWriter(w),
+	}
+	e.out = &countingWriter{w: e.bw}
+	e.timeline = timeline[*image.RGBA]{
+		fps:     opts.FPS,
+		same:    sameImage,
+		similar: similarImages(opts.MergeBelow),
+		write:   e.write,
+		stats:   &e.stats,
+	}
+	return e, nil
+}
+
+// Sample does nothing: APNG frames are not quantized
+func (e *APNGEncoder) Sample(*image.RGBA) {}
+
+// WriteFrame adds the next frame of the animation
+func (e *APNGEncoder) WriteFrame(frame *image.RGBA) error {
+	return e.timeline.add(frame)
+}
+
+// Close writes the last frame and the end of the file, then fills in the
+// frame count
+func (e *APNGEncoder) Close() (*Stats, error) {
+	if err := e.timeline.flush(true); err != nil {
+		return nil, err
+	}
+	if e.last == nil {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	if err := e.chunk("IEND"); err != nil {
+		return nil, err
+	}
+	if err := e.bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(e.stats.Frames))
+	binary.BigEndian.PutUint32(actl[4:], uint32(Plays(e.opts.LoopCount)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte("acTL"))
+	crc.Write(actl)
+	actl = binary.BigEndian.AppendUint32(actl, crc.Sum32())
+	if err := patch(e.w, e.base+e.actl, actl, e.base+e.out.n); err != nil {
+		return nil, err
+	}
+
+	e.stats.Bytes = e.out.n
+	stats := e.stats
+	return &stats, nil
+}
+
+// write writes a frame with its final delay
+func (e *APNGEncoder) write(frame *image.RGBA, delay int) error {
+	b := frame.Bounds()
+	first := e.last == nil
+	if first {
+		if err := e.header(b.Dx(), b.Dy()); err != nil {
+			return err
+		}
+	}
+
+	rect, src, blend := b, frame, byte(apngBlendSource)
+	if e.opts.Delta && !first {
+		rect = changedRect(e.last, frame)
+		var ok bool
+		if e.delta, ok = deltaRGBA(e.delta, e.last, frame, rect); ok {
+			src, blend = e.delta, apngBlendOver
+		}
+	}
+	data, err := e.compress(src, rect)
+	if err != nil {
+		return err
+	}
+
+	fctl := make([]byte, 0, 26)
+	fctl = binary.BigEndian.AppendUint32(fctl, e.nextSeq())
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(rect.Dx()))
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(rect.Dy()))
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(rect.Min.X-b.Min.X))
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(rect.Min.Y-b.Min.Y))
+	fctl = binary.BigEndian.AppendUint16(fctl, uint16(delay))
+	fctl = binary.BigEndian.AppendUint16(fctl, 100)
+	fctl = append(fctl, apngDisposeNone, blend)
+	if err := e.chunk("fcTL", fctl); err != nil {
+		return err
+	}
+
+	// The first frame doubles as the still image shown by plain PNG viewers
+	if first {
+		err = e.chunk("IDAT", data)
+	} else {
+		err = e.chunk("fdAT", binary.BigEndian.AppendUint32(nil, e.nextSeq()), data)
+	}
+	e.last = frame
+	return err
+}
+
+// header writes the signature, the image header and a placeholder
+// animation control chunk
+func (e *APNGEncoder) header(width, height int) error {
+	if _, err := e.out.Write(pngSignature); err != nil {
+		return err
+	}
+	ihdr := make([]byte, 0, 13)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(width))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(height))
+	ihdr = append(ihdr, 8, 6, 0, 0, 0) // 8-bit RGBA, deflate, adaptive filters, no interlace
+	if err := e.chunk("IHDR", ihdr); err != nil {
+		return err
+	}
+	e.actl = e.out.n + 8
+	return e.chunk("acTL", make([]byte, 8))
+}
+
+// chunk writes a PNG chunk whose data is the concatenation of parts
+func (e *APNGEncoder) chunk(typ string, parts ...[]byte) error {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	crc := crc32.NewIEEE()
+	head := binary.BigEndian.AppendUint32(nil, uint32(n))
+	head = append(head, typ...)
+	crc.Write(head[4:])
+	if _, err := e.out.Write(head); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		crc.Write(p)
+		if _, err := e.out.Write(p); err != nil {
+			return err
+		}
+	}
+	_, err := e.out.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+func (e *APNGEncoder) nextSeq() uint32 {
+	e.seq++
+	return e.seq - 1
+}
+
+// compress filters and deflates the rect region of img as PNG image data
+func (e *APNGEncoder) compress(img *image.RGBA, rect image.Rectangle) ([]byte, error) {
+	e.zbuf.Reset()
+	if e.zw == nil {
+		e.zw = zlib.NewWriter(&e.zbuf)
+	} else {
+		e.zw.Reset(&e.zbuf)
+	}
+
+	n := 4 * rect.Dx()
+	prev, cur := make([]byte, n), make([]byte, n)
+	var filtered [5][]byte
+	for i := range filtered {
+		filtered[i] = make([]byte, n+1)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		copy(cur, img.Pix[i:i+n])
+		unpremultiply(cur)
+		if _, err := e.zw.Write(filterRow(filtered, cur, prev)); err != nil {
+			return nil, err
+		}
+		prev, cur = cur, prev
+	}
+	if err := e.zw.Close(); err != nil {
+		return nil, err
+	}
+	return e.zbuf.Bytes(), nil
+}
+
+// filterRow applies each PNG filter to a row of 4-byte pixels and returns
+// the one with the smallest sum of absolute differences, the usual
+// heuristic for what deflates best. dst holds one buffer per filter.
+func filterRow(dst [5][]byte, cur, prev []byte) []byte {
+	const bpp = 4
+	for f := range dst {
+		dst[f][0] = byte(f)
+	}
+	none, sub, up, avg, paeth := dst[0][1:], dst[1][1:], dst[2][1:], dst[3][1:], dst[4][1:]
+	for i := range cur {
+		var left, upLeft byte
+		if i >= bpp {
+			left, upLeft = cur[i-bpp], prev[i-bpp]
+		}
+		above := prev[i]
+		none[i] = cur[i]
+		sub[i] = cur[i] - left
+		up[i] = cur[i] - above
+		avg[i] = cur[i] - byte((int(left)+int(above))/2)
+		paeth[i] = cur[i] - paethPredictor(left, above, upLeft)
+	}
+
+	best, bestSum := 0, -1
+	for f := range dst {
+		sum := 0
+		for _, v := range dst[f][1:] {
+			sum += min(int(v), 256-int(v))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = f, sum
+		}
+	}
+	return dst[best]
+}
+
+// paethPredictor picks whichever of a (left), b (above) and c (upper
+// left) is closest to a+b-c
+func paethPredictor(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// patch overwrites data at offset off of w, then seeks back to end
+func patch(w io.WriteSeeker, off int64, data []byte, end int64) error {
+	if _, err := w.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Seek(end, io.SeekStart)
+	return err
+}
@@ -0,0 +1,186 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+)
+
+// transparent is the palette entry delta frames use for unchanged pixels
+var transparent = color.RGBA{0, 0, 0, 0}
+
+// withTransparency appends the transparent entry to a palette
+func withTransparency(palette color.Palette) color.Palette {
+	return append(palette, transparent)
+}
+
+// delta returns the part of next that differs from prev. It is cropped
+// to the bounding box of changed pixels, and unchanged pixels inside it
+// are made transparent so runs of them compress well. The result is meant
+// to be drawn without disposal on top of prev. next's palette must end in
+// the transparent entry.
+func delta(prev, next *image.Paletted) *image.Paletted {
+	same := samePixel(prev, next)
+	bounds := next.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	minX, minY, maxX, maxY := w, h, -1, -1
+	for y := 0; y < h; y++ {
+		p := prev.Pix[y*prev.Stride:]
+		n := next.Pix[y*next.Stride:]
+		for x := 0; x < w; x++ {
+			if same(p[x], n[x]) {
+				continue
+			}
+			minX, maxX = min(minX, x), max(maxX, x)
+			minY, maxY = min(minY, y), max(maxY, y)
+		}
+	}
+
+	clear := uint8(len(next.Palette) - 1)
+
+	// Nothing changed: a single transparent pixel keeps the frame's delay
+	if maxX < 0 {
+		img := image.NewPaletted(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+1, bounds.Min.Y+1), next.Palette)
+		img.Pix[0] = clear
+		return img
+	}
+
+	rect := image.Rect(minX, minY, maxX+1, maxY+1).Add(bounds.Min)
+	img := image.NewPaletted(rect, next.Palette)
+	for y := minY; y <= maxY; y++ {
+		p := prev.Pix[y*prev.Stride:]
+		n := next.Pix[y*next.Stride:]
+		dst := img.Pix[(y-minY)*img.Stride:]
+		for x := minX; x <= maxX; x++ {
+			if same(p[x], n[x]) {
+				dst[x-minX] = clear
+			} else {
+				dst[x-minX] = n[x]
+			}
+		}
+	}
+	return img
+}
+
+// samePixel compares palette indices of two frames. With a shared palette
+// the indices themselves are compared; otherwise their colours are.
+func samePixel(a, b *image.Paletted) func(x, y uint8) bool {
+	if samePalette(a.Palette, b.Palette) {
+		return func(x, y uint8) bool { return x == y }
+	}
+	ca, cb := paletteRGB(a.Palette), paletteRGB(b.Palette)
+	return func(x, y uint8) bool { return ca[x] == cb[y] }
+}
+
+func samePalette(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// paletteRGB packs every palette entry into a comparable value
+func paletteRGB(palette color.Palette) [256]uint32 {
+	var out [256]uint32
+	for i, c := range palette {
+		r, g, b, a := c.RGBA()
+		out[i] = (r>>8)<<24 | (g>>8)<<16 | (b>>8)<<8 | a>>8
+	}
+	return out
+}
+
+// sameImage reports whether two full-colour frames have identical pixels
+func sameImage(a, b *image.RGBA) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		if !bytes.Equal(rowPix(a, y), rowPix(b, y)) {
+			return false
+		}
+	}
+	return true
+}
+
+// changedRect returns the bounding box of the pixels that differ between
+// two full-colour frames of the same size. When nothing changed it is a
+// single pixel, so the frame can still carry its delay.
+func changedRect(prev, next *image.RGBA) image.Rectangle {
+	b := next.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X-1, b.Min.Y-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p, n := rowPix(prev, y), rowPix(next, y)
+		if bytes.Equal(p, n) {
+			continue
+		}
+		minY, maxY = min(minY, y), y
+		for x := 0; x < len(n); x += 4 {
+			if !bytes.Equal(p[x:x+4], n[x:x+4]) {
+				minX, maxX = min(minX, b.Min.X+x/4), max(maxX, b.Min.X+x/4)
+			}
+		}
+	}
+	if maxX < minX {
+		return image.Rect(b.Min.X, b.Min.Y, b.Min.X+1, b.Min.Y+1)
+	}
+	return image.Rect(minX, minY, maxX+1, maxY+1)
+}
+
+// deltaRGBA returns the rect region of next with the pixels unchanged
+// since prev made transparent, so runs of them compress well. The result
+// is meant to be alpha blended on top of prev. ok is false when a changed
+// pixel is itself translucent: blending would mix it with prev, so the
+// frame has to replace the region instead. buf is reused when it is big
+// enough.
+func deltaRGBA(buf *image.RGBA, prev, next *image.RGBA, rect image.Rectangle) (img *image.RGBA, ok bool) {
+	if buf == nil || len(buf.Pix) < 4*rect.Dx()*rect.Dy() {
+		buf = image.NewRGBA(rect)
+	} else {
+		buf = &image.RGBA{Pix: buf.Pix[:4*rect.Dx()*rect.Dy()], Stride: 4 * rect.Dx(), Rect: rect}
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := next.PixOffset(rect.Min.X, y)
+		n := next.Pix[i : i+4*rect.Dx()]
+		p := prev.Pix[i : i+4*rect.Dx()]
+		dst := buf.Pix[buf.PixOffset(rect.Min.X, y):]
+		for x := 0; x < len(n); x += 4 {
+			if bytes.Equal(p[x:x+4], n[x:x+4]) {
+				clear(dst[x : x+4])
+				continue
+			}
+			if n[x+3] != 0xff {
+				return buf, false
+			}
+			copy(dst[x:x+4], n[x:x+4])
+		}
+	}
+	return buf, true
+}
+
+// rowPix returns the pixels of row y of img
+func rowPix(img *image.RGBA, y int) []byte {
+	i := img.PixOffset(img.Rect.Min.X, y)
+	return img.Pix[i : i+4*img.Rect.Dx()]
+}
+
+// unpremultiply converts premultiplied RGBA pixels to straight alpha in
+// place, as PNG and WebP store them
+func unpremultiply(pix []byte) {
+	for i := 0; i < len(pix); i += 4 {
+		switch a := uint32(pix[i+3]); a {
+		case 0xff:
+		case 0:
+			pix[i], pix[i+1], pix[i+2] = 0, 0, 0
+		default:
+			pix[i] = uint8(uint32(pix[i]) * 0xff / a)
+			pix[i+1] = uint8(uint32(pix[i+1]) * 0xff / a)
+			pix[i+2] = uint8(uint32(pix[i+2]) * 0xff / a)
+		}
+	}
+}
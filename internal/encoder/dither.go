@@ -0,0 +1,200 @@
+package encoder
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Dithering modes
+const (
+	DitherNone           = "none"    // Map each pixel to its nearest palette colour
+	DitherFloydSteinberg = "floyd"   // Diffuse the quantization error to neighbouring pixels
+	DitherOrdered        = "ordered" // Offset pixels by an 8x8 Bayer threshold matrix
+)
+
+// orderedSpread is how far, per channel, the Bayer matrix moves a pixel
+// before it is mapped to the palette
+const orderedSpread = 32
+
+// bayer8 is the 8x8 Bayer threshold matrix
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// ditherFunc returns the quantizer for a dithering mode
+func ditherFunc(mode string) (func(*quantizer, *image.RGBA) *image.Paletted, error) {
+	switch mode {
+	case "", DitherNone:
+		return quantizeNearest, nil
+	case DitherFloydSteinberg:
+		return quantizeFloydSteinberg, nil
+	case DitherOrdered:
+		return quantizeOrdered, nil
+	default:
+		return nil, fmt.Errorf("unknown dither mode %q (want %s, %s or %s)", mode, DitherNone, DitherFloydSteinberg, DitherOrdered)
+	}
+}
+
+// quantizer maps colours to palette indices, caching lookups since code
+// frames repeat the same few colours over and over
+type quantizer struct {
+	palette color.Palette
+	rgb     [][3]int32
+	opaque  []bool // Transparent entries are never picked for a pixel
+	exact   map[uint32]uint8
+	cache   map[uint32]uint8
+}
+
+func newQuantizer(palette color.Palette) *quantizer {
+	q := &quantizer{
+		palette: palette,
+		rgb:     make([][3]int32, len(palette)),
+		opaque:  make([]bool, len(palette)),
+		exact:   map[uint32]uint8{},
+		cache:   map[uint32]uint8{},
+	}
+	for i, c := range palette {
+		r, g, b, a := c.RGBA()
+		if a == 0 {
+			continue
+		}
+		q.opaque[i] = true
+		q.rgb[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+		key := rgbKey(q.rgb[i][0], q.rgb[i][1], q.rgb[i][2])
+		if _, ok := q.exact[key]; !ok {
+			q.exact[key] = uint8(i)
+		}
+	}
+	return q
+}
+
+func rgbKey(r, g, b int32) uint32 {
+	return uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+}
+
+// nearest returns the index of the palette colour closest to r, g, b
+func (q *quantizer) nearest(r, g, b int32) uint8 {
+	key := rgbKey(r, g, b)
+	if idx, ok := q.cache[key]; ok {
+		return idx
+	}
+	best, bestDist := 0, int32(1<<31-1)
+	for i, p := range q.rgb {
+		if !q.opaque[i] {
+			continue
+		}
+		dr, dg, db := r-p[0], g-p[1], b-p[2]
+		// Weight green highest and blue lowest, roughly as the eye does
+		if d := 3*dr*dr + 4*dg*dg + 2*db*db; d < bestDist {
+			best, bestDist = i, d
+			if d == 0 {
+				break
+			}
+		}
+	}
+	q.cache[key] = uint8(best)
+	return uint8(best)
+}
+
+// quantizeNearest maps every pixel to its nearest palette colour
+func quantizeNearest(q *quantizer, frame *image.RGBA) *image.Paletted {
+	bounds := frame.Bounds()
+	out := image.NewPaletted(bounds, q.palette)
+	for y := 0; y < bounds.Dy(); y++ {
+		src := frame.Pix[y*frame.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			i := x * 4
+			dst[x] = q.nearest(int32(src[i]), int32(src[i+1]), int32(src[i+2]))
+		}
+	}
+	return out
+}
+
+// quantizeFloydSteinberg diffuses quantization error to the right and
+// below. Pixels that are exactly a palette colour, such as flat backgrounds
+// and solid glyph strokes, are left alone and absorb no error, so noise
+// stays in gradients and anti-aliased edges.
+func quantizeFloydSteinberg(q *quantizer, frame *image.RGBA) *image.Paletted {
+	bounds := frame.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewPaletted(bounds, q.palette)
+
+	// Error rows for the current and next line, in 1/16ths, with a
+	// pixel of padding on each side
+	curr := make([][3]int32, w+2)
+	next := make([][3]int32, w+2)
+
+	for y := 0; y < h; y++ {
+		src := frame.Pix[y*frame.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			i := x * 4
+			r, g, b := int32(src[i]), int32(src[i+1]), int32(src[i+2])
+			if idx, ok := q.exact[rgbKey(r, g, b)]; ok {
+				dst[x] = idx
+				continue
+			}
+
+			e := curr[x+1]
+			r = clamp8(r + e[0]/16)
+			g = clamp8(g + e[1]/16)
+			b = clamp8(b + e[2]/16)
+			idx := q.nearest(r, g, b)
+			dst[x] = idx
+
+			p := q.rgb[idx]
+			er, eg, eb := r-p[0], g-p[1], b-p[2]
+			for _, d := range [...]struct {
+				row *[][3]int32
+				dx  int
+				w   int32
+			}{{&curr, 2, 7}, {&next, 0, 3}, {&next, 1, 5}, {&next, 2, 1}} {
+				cell := &(*d.row)[x+d.dx]
+				cell[0] += er * d.w
+				cell[1] += eg * d.w
+				cell[2] += eb * d.w
+			}
+		}
+		curr, next = next, curr
+		clear(next)
+	}
+	return out
+}
+
+// quantizeOrdered offsets pixels by a Bayer threshold before mapping them.
+// The pattern is fixed per pixel position, so unchanged areas stay
+// identical from frame to frame instead of shimmering. Exact palette
+// colours are kept as they are.
+func quantizeOrdered(q *quantizer, frame *image.RGBA) *image.Paletted {
+	bounds := frame.Bounds()
+	out := image.NewPaletted(bounds, q.palette)
+	for y := 0; y < bounds.Dy(); y++ {
+		src := frame.Pix[y*frame.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			i := x * 4
+			r, g, b := int32(src[i]), int32(src[i+1]), int32(src[i+2])
+			if idx, ok := q.exact[rgbKey(r, g, b)]; ok {
+				dst[x] = idx
+				continue
+			}
+			offset := int32((bayer8[y%8][x%8]*2 - 63) * orderedSpread / 128)
+			dst[x] = q.nearest(clamp8(r+offset), clamp8(g+offset), clamp8(b+offset))
+		}
+	}
+	return out
+}
+
+// clamp8 limits a channel value to 0-255
+func clamp8(v int32) int32 {
+	return max(0, min(255, v))
+}
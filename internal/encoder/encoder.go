@@ -1,45 +1,272 @@
 package encoder
 
 import (
+	"fmt"
 	"image"
-	"image/color/palette"
-	"image/draw"
-	"image/gif"
+	"image/color"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-// EncodeGIF encodes frames into an animated GIF
-func EncodeGIF(frames []*image.RGBA, outputPath string, fps int) error {
-	// Create output file
-	outFile, err := os.Create(outputPath)
+// Palette modes
+const (
+	PaletteGlobal = "global" // One adaptive palette shared by every frame
+	PaletteLocal  = "local"  // An adaptive palette per frame
+)
+
+// Output formats
+const (
+	FormatGIF    = "gif"    // 256 colours per frame, plays everywhere
+	FormatAPNG   = "apng"   // Animated PNG, full 24-bit colour and alpha
+	FormatWebP   = "webp"   // Animated lossless WebP, full colour and alpha
+	FormatMP4    = "mp4"    // H.264 video, encoded by ffmpeg
+	FormatWebM   = "webm"   // VP9 video, encoded by ffmpeg
+	FormatPNG    = "png"    // A still of the finished code
+	FormatFrames = "frames" // A directory of numbered PNGs and a JSON timing manifest
+	FormatSVG    = "svg"    // Animated SVG with the code kept as text
+	FormatCast   = "cast"   // asciinema (asciicast v2) terminal recording
+)
+
+// Options controls how frames are quantized and written
+type Options struct {
+	FPS     int
+	Palette string // PaletteGlobal or PaletteLocal (GIF only)
+	Dither  string // DitherNone, DitherFloydSteinberg or DitherOrdered (GIF only)
+	Colors  int    // Palette size, 2-256; 0 means 256 (GIF only)
+
+	// ThemeColors get exact palette entries so syntax colours survive
+	// quantization unchanged (GIF only)
+	ThemeColors []color.RGBA
+
+	// LoopCount is the GIF loop count: LoopForever, LoopOnce or the number
+	// of repeats after the first play. See ParseLoop.
+	LoopCount int
+
+	// Delta stores each frame as only the region that changed since the
+	// previous one
+	Delta bool
+
+	// MergeBelow merges a frame into the one before when less than this
+	// fraction of their pixels differ, trading smoothness for size. Zero
+	// merges identical frames only. (GIF, APNG and WebP)
+	MergeBelow float64
+}
+
+// Stats describes an encoded animation
+type Stats struct {
+	Frames     int   // Frames written
+	Duplicates int   // Identical consecutive frames merged into longer delays
+	Merged     int   // Nearly identical frames merged under Options.MergeBelow
+	Dropped    int   // Frames dropped because their delay was too short for browsers
+	Bytes      int64 // Size of the written file
+	RawBytes   int64 // Estimated size the file would have with full-canvas frames (GIF only, 0 if unknown)
+}
+
+// Encoder writes the frames of an animation in one output format. Frames
+// are fed with WriteFrame at Options.FPS, and Close finishes the file.
+type Encoder interface {
+	// Sample offers a frame ahead of time for encoders that build a
+	// palette from the whole animation. Others ignore it.
+	Sample(frame *image.RGBA)
+	WriteFrame(frame *image.RGBA) error
+	Close() (*Stats, error)
+}
+
+// Aborter is implemented by encoders that can stop without finishing the
+// file, discarding what they wrote
+type Aborter interface {
+	Abort()
+}
+
+// Abort stops enc after a failure. Encoders that cannot abort are closed
+// and their error ignored.
+func Abort(enc Encoder) {
+	if a, ok := enc.(Aborter); ok {
+		a.Abort()
+		return
+	}
+	enc.Close()
+}
+
+// format describes how to write one output format. Formats written by
+// an external program have create instead of new and need a file path.
+// Formats written from the code itself rather than from frames have no
+// encoder.
+type format struct {
+	ext    string // Default file extension
+	new    func(w io.WriteSeeker, opts Options) (Encoder, error)
+	create func(path string, opts Options) (Encoder, error)
+	exact  bool // Frames keep their exact timing, free of browser delay limits
+	still  bool // One image rather than an animation
+	code   bool // Written from the code, not encoded from frames
+}
+
+var formats = map[string]format{
+	FormatGIF: {ext: ".gif", new: func(w io.WriteSeeker, opts Options) (Encoder, error) {
+		return NewGIFEncoder(w, opts)
+	}},
+	FormatAPNG: {ext: ".png", new: func(w io.WriteSeeker, opts Options) (Encoder, error) {
+		return NewAPNGEncoder(w, opts)
+	}},
+	FormatWebP: {ext: ".webp", new: func(w io.WriteSeeker, opts Options) (Encoder, error) {
+		return NewWebPEncoder(w, opts)
+	}},
+	FormatMP4: {ext: ".mp4", exact: true, create: func(path string, opts Options) (Encoder, error) {
+		return NewFFmpegEncoder(path, FormatMP4, opts)
+	}},
+	FormatWebM: {ext: ".webm", exact: true, create: func(path string, opts Options) (Encoder, error) {
+		return NewFFmpegEncoder(path, FormatWebM, opts)
+	}},
+	FormatPNG: {ext: ".png", still: true, new: func(w io.WriteSeeker, opts Options) (Encoder, error) {
+		return NewPNGEncoder(w, opts)
+	}},
+	// The output path names the directory
+	FormatFrames: {ext: "", exact: true, create: func(path string, opts Options) (Encoder, error) {
+		return NewFramesEncoder(path, opts)
+	}},
+	FormatSVG:  {ext: ".svg", exact: true, code: true},
+	FormatCast: {ext: ".cast", exact: true, code: true},
+}
+
+// Formats returns the names of the supported output formats
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFormat checks that name is a supported output format
+func ValidateFormat(name string) error {
+	if _, ok := formats[name]; !ok {
+		return fmt.Errorf("unknown format %q (want one of: %s)", name, strings.Join(Formats(), ", "))
+	}
+	return nil
+}
+
+// FormatForPath picks the output format from a file extension, falling
+// back to GIF. A .png file is taken to be an animated PNG; use FormatPNG
+// for a still.
+func FormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".apng":
+		return FormatAPNG
+	case ".webp":
+		return FormatWebP
+	case ".mp4", ".m4v":
+		return FormatMP4
+	case ".webm":
+		return FormatWebM
+	case ".svg":
+		return FormatSVG
+	case ".cast":
+		return FormatCast
+	}
+	return FormatGIF
+}
+
+// Extension returns the usual file extension for a format
+func Extension(name string) string {
+	return formats[name].ext
+}
+
+// ExactTiming reports whether a format keeps every frame's exact timing.
+// Other formats are bound by the shortest delay browsers honor.
+func ExactTiming(name string) bool {
+	return formats[name].exact
+}
+
+// IsStill reports whether a format holds a single still image rather
+// than an animation
+func IsStill(name string) bool {
+	return formats[name].still
+}
+
+// FromCode reports whether a format is written from the code itself
+// rather than encoded from rendered frames, so it has no encoder
+func FromCode(name string) bool {
+	return formats[name].code
+}
+
+// New creates an encoder writing the named format to w
+func New(w io.WriteSeeker, name string, opts Options) (Encoder, error) {
+	if err := checkOptions(name, opts); err != nil {
+		return nil, err
+	}
+	if formats[name].new == nil {
+		return nil, fmt.Errorf("%s output can only be written to a file", name)
+	}
+	return formats[name].new(w, opts)
+}
+
+// Create creates the file at path and an encoder writing the named format
+// to it. Closing the encoder closes the file.
+func Create(path, name string, opts Options) (Encoder, error) {
+	if err := checkOptions(name, opts); err != nil {
+		return nil, err
+	}
+	if create := formats[name].create; create != nil {
+		return create(path, opts)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := New(f, name, opts)
 	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileEncoder{Encoder: enc, f: f}, nil
+}
+
+// checkOptions validates the options every format relies on
+func checkOptions(name string, opts Options) error {
+	if err := ValidateFormat(name); err != nil {
 		return err
 	}
-	defer outFile.Close()
-
-	// Convert frames to paletted images
-	palettedFrames := make([]*image.Paletted, len(frames))
-	delays := make([]int, len(frames))
-	
-	// Calculate delay (in 100ths of a second)
-	delay := 100 / fps
-
-	for i, frame := range frames {
-		bounds := frame.Bounds()
-		
-		// Create paletted image with Plan9 palette (256 colors)
-		palettedImage := image.NewPaletted(bounds, palette.Plan9)
-		
-		// Use draw to properly convert RGBA to paletted with dithering
-		draw.Draw(palettedImage, bounds, frame, bounds.Min, draw.Src)
-		
-		palettedFrames[i] = palettedImage
-		delays[i] = delay
-	}
-
-	// Encode GIF
-	return gif.EncodeAll(outFile, &gif.GIF{
-		Image: palettedFrames,
-		Delay: delays,
-	})
+	if opts.FPS <= 0 {
+		return fmt.Errorf("fps must be positive, got %d", opts.FPS)
+	}
+	if opts.MergeBelow < 0 || opts.MergeBelow >= 1 {
+		return fmt.Errorf("merge threshold must be at least 0 and below 1, got %g", opts.MergeBelow)
+	}
+	if FromCode(name) {
+		return fmt.Errorf("%s output is written from the code and has no frame encoder", name)
+	}
+	return nil
+}
+
+// fileEncoder closes the output file along with the encoder
+type fileEncoder struct {
+	Encoder
+	f *os.File
+}
+
+func (e *fileEncoder) Close() (*Stats, error) {
+	stats, err := e.Encoder.Close()
+	if cerr := e.f.Close(); err == nil && cerr != nil {
+		return nil, cerr
+	}
+	return stats, err
+}
+
+// countingWriter counts the bytes written through it. Without an
+// underlying writer it only counts.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.w == nil {
+		return len(p), nil
+	}
+	return c.w.Write(p)
 }
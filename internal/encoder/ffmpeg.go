@@ -0,0 +1,171 @@
+package encoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrFFmpegNotFound is returned for video formats when no ffmpeg binary is
+// on the PATH
+var ErrFFmpegNotFound = errors.New("ffmpeg not found in PATH (install it from https://ffmpeg.org to export video)")
+
+// videoCodecs are the ffmpeg output options for each video format. Both
+// use 4:2:0 chroma, which every player supports.
+var videoCodecs = map[string][]string{
+	FormatMP4: {
+		"-c:v", "libx264", "-preset", "slow", "-crf", "18", "-tune", "animation",
+		"-pix_fmt", "yuv420p", "-movflags", "+faststart",
+	},
+	FormatWebM: {
+		"-c:v", "libvpx-vp9", "-crf", "30", "-b:v", "0", "-row-mt", "1",
+		"-pix_fmt", "yuv420p",
+	},
+}
+
+// FFmpegEncoder streams frames as raw RGBA video into a local ffmpeg
+// process, which encodes them at a constant frame rate. Every frame is
+// kept, duplicates included, so the video plays at exactly Options.FPS;
+// video has no loop count. Transparent pixels come out black, as in GIFs.
+type FFmpegEncoder struct {
+	path   string
+	format string
+	opts   Options
+	bin    string
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+	exited bool
+	size   image.Rectangle // Size of the first frame; every frame must match
+	stats  Stats
+}
+
+// NewFFmpegEncoder creates an encoder writing a video in format (FormatMP4
+// or FormatWebM) to path. ffmpeg starts with the first frame, once the
+// frame size is known.
+func NewFFmpegEncoder(path, format string, opts Options) (*FFmpegEncoder, error) {
+	if _, ok := videoCodecs[format]; !ok {
+		return nil, fmt.Errorf("ffmpeg cannot write format %q", format)
+	}
+	bin, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, ErrFFmpegNotFound
+	}
+	return &FFmpegEncoder{path: path, format: format, opts: opts, bin: bin}, nil
+}
+
+// Sample does nothing: video frames are not quantized
+func (e *FFmpegEncoder) Sample(*image.RGBA) {}
+
+// WriteFrame sends the next frame to ffmpeg
+func (e *FFmpegEncoder) WriteFrame(frame *image.RGBA) error {
+	b := frame.Bounds()
+	if e.cmd == nil {
+		if err := e.start(b.Dx(), b.Dy()); err != nil {
+			return err
+		}
+		e.size = b
+	}
+	if b.Size() != e.size.Size() {
+		return fmt.Errorf("frame %d is %dx%d, want %dx%d", e.stats.Frames, b.Dx(), b.Dy(), e.size.Dx(), e.size.Dy())
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if _, err := e.stdin.Write(rowPix(frame, y)); err != nil {
+			// ffmpeg exited early; its own message says why
+			return e.wait(err)
+		}
+	}
+	e.stats.Frames++
+	return nil
+}
+
+// Close waits for ffmpeg to finish the file
+func (e *FFmpegEncoder) Close() (*Stats, error) {
+	if e.cmd == nil {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	if e.exited {
+		return nil, fmt.Errorf("ffmpeg already exited")
+	}
+	if err := e.stdin.Close(); err != nil {
+		return nil, e.wait(err)
+	}
+	if err := e.wait(nil); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return nil, err
+	}
+	e.stats.Bytes = info.Size()
+	stats := e.stats
+	return &stats, nil
+}
+
+// Abort kills ffmpeg and removes the partial video
+func (e *FFmpegEncoder) Abort() {
+	if e.cmd == nil {
+		return
+	}
+	if !e.exited {
+		e.stdin.Close()
+		e.cmd.Process.Kill()
+		e.cmd.Wait()
+		e.exited = true
+	}
+	os.Remove(e.path)
+}
+
+// start launches ffmpeg reading raw frames of the given size from stdin
+func (e *FFmpegEncoder) start(width, height int) error {
+	fps := strconv.Itoa(e.opts.FPS)
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-f", "rawvideo", "-pix_fmt", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", width, height),
+		"-framerate", fps,
+		"-i", "-",
+		// 4:2:0 chroma needs even dimensions
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2",
+	}
+	args = append(args, videoCodecs[e.format]...)
+	args = append(args, "-r", fps, e.path)
+
+	e.cmd = exec.Command(e.bin, args...)
+	e.cmd.Stderr = &e.stderr
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	e.stdin = stdin
+	if err := e.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// wait waits for ffmpeg to exit. A failure is reported with ffmpeg's own
+// error output, falling back to cause.
+func (e *FFmpegEncoder) wait(cause error) error {
+	err := e.cmd.Wait()
+	e.exited = true
+	if err == nil {
+		return cause
+	}
+	if msg := strings.TrimSpace(e.stderr.String()); msg != "" {
+		return fmt.Errorf("ffmpeg failed: %s", msg)
+	}
+	if cause != nil {
+		return fmt.Errorf("ffmpeg failed: %w", cause)
+	}
+	return fmt.Errorf("ffmpeg failed: %w", err)
+}
@@ -0,0 +1,188 @@
+package encoder
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// GIFEncoder quantizes and writes frames as they arrive, holding at most
+// the frame waiting for its delay and the last frame written. Memory use
+// therefore does not grow with the length of the animation.
+//
+// Frames are fed with WriteFrame at the configured FPS. In global palette
+// mode, frames passed to Sample before the first WriteFrame decide the
+// palette; without samples the first frame does.
+type GIFEncoder struct {
+	opts   Options
+	dither func(*quantizer, *image.RGBA) *image.Paletted
+	colors int // Palette entries available to colours
+
+	out       *countingWriter
+	gw        *gifWriter
+	raw       countingWriter // Header and trailer of the full-canvas equivalent
+	rawGW     *gifWriter
+	rawFrame  int64 // Last measured size of a full-canvas frame
+	rawFrames int64 // Estimated size of every frame at full canvas
+	shared    *quantizer
+	sample    map[[3]uint8]int
+
+	timeline timeline[*image.Paletted]
+	last     *image.Paletted
+	stats    Stats
+}
+
+// NewGIFEncoder creates an encoder writing a GIF to w
+func NewGIFEncoder(w io.Writer, opts Options) (*GIFEncoder, error) {
+	switch opts.Palette {
+	case "":
+		opts.Palette = PaletteGlobal
+	case PaletteGlobal, PaletteLocal:
+	default:
+		return nil, fmt.Errorf("unknown palette mode %q (want %s or %s)", opts.Palette, PaletteGlobal, PaletteLocal)
+	}
+	dither, err := ditherFunc(opts.Dither)
+	if err != nil {
+		return nil, err
+	}
+	if opts.FPS <= 0 {
+		return nil, fmt.Errorf("fps must be positive, got %d", opts.FPS)
+	}
+	switch {
+	case opts.Colors == 0:
+		opts.Colors = maxColors
+	case opts.Colors < 2 || opts.Colors > maxColors:
+		return nil, fmt.Errorf("colors must be between 2 and %d, got %d", maxColors, opts.Colors)
+	}
+
+	e := &GIFEncoder{
+		opts:   opts,
+		dither: dither,
+		colors: opts.Colors,
+		out:    &countingWriter{w: w},
+		sample: map[[3]uint8]int{},
+	}
+	// Delta frames need one palette entry for transparency
+	if opts.Delta {
+		e.colors--
+	}
+	e.gw = newGIFWriter(e.out)
+	e.rawGW = newGIFWriter(&e.raw)
+	e.timeline = timeline[*image.Paletted]{
+		fps:     opts.FPS,
+		same:    sameFrame,
+		similar: similarFrames(opts.MergeBelow),
+		write:   e.write,
+		stats:   &e.stats,
+	}
+	return e, nil
+}
+
+// Sample adds a frame's colours to the histogram the global palette is
+// built from. It has no effect once frames are being written.
+func (e *GIFEncoder) Sample(frame *image.RGBA) {
+	if e.timeline.index > 0 {
+		return
+	}
+	addSample(e.sample, frame, maxSamplePixels/maxSampleFrames)
+}
+
+// WriteFrame quantizes the next frame of the animation. The frame is
+// written once the following frame shows whether it is a duplicate.
+func (e *GIFEncoder) WriteFrame(frame *image.RGBA) error {
+	q := e.shared
+	if q == nil {
+		if e.opts.Palette == PaletteLocal {
+			counts := map[[3]uint8]int{}
+			addSample(counts, frame, maxSamplePixels)
+			q = e.newQuantizer(counts)
+		} else {
+			if len(e.sample) == 0 {
+				addSample(e.sample, frame, maxSamplePixels)
+			}
+			e.shared = e.newQuantizer(e.sample)
+			e.sample = nil
+			q = e.shared
+		}
+	}
+	return e.timeline.add(e.dither(q, frame))
+}
+
+// Close writes the last frame and the trailer
+func (e *GIFEncoder) Close() (*Stats, error) {
+	if err := e.timeline.flush(true); err != nil {
+		return nil, err
+	}
+	if e.last == nil {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	if err := e.gw.trailer(); err != nil {
+		return nil, err
+	}
+	e.rawGW.trailer()
+
+	e.stats.Bytes = e.out.n
+	e.stats.RawBytes = e.raw.n + e.rawFrames
+	if !e.opts.Delta {
+		e.stats.RawBytes = e.stats.Bytes
+	}
+	stats := e.stats
+	return &stats, nil
+}
+
+// write writes a quantized frame with its final delay
+func (e *GIFEncoder) write(frame *image.Paletted, delay int) error {
+	local := e.opts.Palette == PaletteLocal
+	if e.last == nil {
+		b := frame.Bounds()
+		var global color.Palette
+		if !local {
+			global = frame.Palette
+		}
+		e.gw.header(b.Dx(), b.Dy(), global, e.opts.LoopCount)
+		if e.opts.Delta {
+			e.rawGW.header(b.Dx(), b.Dy(), global, e.opts.LoopCount)
+		}
+	}
+
+	// Each delta frame is drawn over the previous one, so nothing is disposed
+	img := frame
+	if e.opts.Delta {
+		if (e.stats.Frames-1)%rawSampleEvery == 0 {
+			e.rawFrame = fullFrameSize(frame, delay, local)
+		}
+		e.rawFrames += e.rawFrame
+		if e.last != nil {
+			img = delta(e.last, frame)
+		}
+	}
+	e.gw.frame(img, delay, gif.DisposalNone, local)
+	e.last = frame
+	return e.gw.err
+}
+
+// rawSampleEvery is how often the full-canvas size of a written frame is
+// measured for the delta encoding report. The frames in between reuse the
+// last measurement, which keeps the estimate close at a fraction of the
+// cost of compressing every frame twice.
+const rawSampleEvery = 8
+
+// fullFrameSize returns the encoded size of frame covering the whole canvas
+func fullFrameSize(frame *image.Paletted, delay int, local bool) int64 {
+	var n countingWriter
+	g := newGIFWriter(&n)
+	g.frame(frame, delay, gif.DisposalNone, local)
+	g.w.Flush()
+	return n.n
+}
+
+// newQuantizer builds a palette from a colour histogram
+func (e *GIFEncoder) newQuantizer(counts map[[3]uint8]int) *quantizer {
+	palette := buildPalette(counts, e.opts.ThemeColors, e.colors)
+	if e.opts.Delta {
+		palette = withTransparency(palette)
+	}
+	return newQuantizer(palette)
+}
@@ -0,0 +1,173 @@
+package encoder
+
+import (
+	"bufio"
+	"compress/lzw"
+	"image"
+	"image/color"
+	"io"
+)
+
+// GIF block markers
+const (
+	gifExtension      = 0x21
+	gifImageSeparator = 0x2C
+	gifTrailer        = 0x3B
+
+	gifGraphicControl = 0xF9
+	gifApplication    = 0xFF
+)
+
+// gifWriter writes the blocks of a GIF89a stream one at a time. Unlike
+// image/gif.EncodeAll it never needs the whole animation in memory.
+type gifWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func newGIFWriter(w io.Writer) *gifWriter {
+	return &gifWriter{w: bufio.NewWriter(w)}
+}
+
+func (g *gifWriter) write(p ...byte) {
+	if g.err == nil {
+		_, g.err = g.w.Write(p)
+	}
+}
+
+func (g *gifWriter) uint16(v int) {
+	g.write(byte(v), byte(v>>8))
+}
+
+// header writes the signature, the logical screen and the global colour
+// table, plus the looping extension unless loopCount is LoopOnce
+func (g *gifWriter) header(width, height int, global color.Palette, loopCount int) {
+	g.write([]byte("GIF89a")...)
+	g.uint16(width)
+	g.uint16(height)
+	if len(global) > 0 {
+		bits := paletteBits(len(global))
+		g.write(0x80|0x70|byte(bits-1), 0, 0)
+		g.colorTable(global, bits)
+	} else {
+		g.write(0x70, 0, 0)
+	}
+
+	if loopCount >= 0 {
+		g.write(gifExtension, gifApplication, 11)
+		g.write([]byte("NETSCAPE2.0")...)
+		g.write(3, 1)
+		g.uint16(loopCount)
+		g.write(0)
+	}
+}
+
+// frame writes one image with its graphic control extension. The local
+// colour table is written only when local is true.
+func (g *gifWriter) frame(img *image.Paletted, delay int, disposal byte, local bool) {
+	transparentIndex := -1
+	for i, c := range img.Palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparentIndex = i
+			break
+		}
+	}
+
+	g.write(gifExtension, gifGraphicControl, 4)
+	flags := disposal << 2
+	if transparentIndex >= 0 {
+		flags |= 1
+	}
+	g.write(flags)
+	g.uint16(delay)
+	g.write(byte(max(transparentIndex, 0)), 0)
+
+	b := img.Bounds()
+	g.write(gifImageSeparator)
+	g.uint16(b.Min.X)
+	g.uint16(b.Min.Y)
+	g.uint16(b.Dx())
+	g.uint16(b.Dy())
+
+	bits := paletteBits(len(img.Palette))
+	if local {
+		g.write(0x80 | byte(bits-1))
+		g.colorTable(img.Palette, bits)
+	} else {
+		g.write(0)
+	}
+
+	// Codes start one bit wider than the pixels, and GIF needs at least 2
+	litWidth := max(2, bits)
+	g.write(byte(litWidth))
+	blocks := &subBlockWriter{g: g}
+	lzww := lzw.NewWriter(blocks, lzw.LSB, litWidth)
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+b.Dx()]
+		if _, err := lzww.Write(row); err != nil && g.err == nil {
+			g.err = err
+		}
+	}
+	if err := lzww.Close(); err != nil && g.err == nil {
+		g.err = err
+	}
+	blocks.flush()
+	g.write(0)
+}
+
+// trailer ends the stream and flushes buffered output
+func (g *gifWriter) trailer() error {
+	g.write(gifTrailer)
+	if g.err == nil {
+		g.err = g.w.Flush()
+	}
+	return g.err
+}
+
+// colorTable writes a palette padded to 2^bits entries
+func (g *gifWriter) colorTable(palette color.Palette, bits int) {
+	for i := 0; i < 1<<bits; i++ {
+		if i >= len(palette) {
+			g.write(0, 0, 0)
+			continue
+		}
+		r, gr, b, _ := palette[i].RGBA()
+		g.write(byte(r>>8), byte(gr>>8), byte(b>>8))
+	}
+}
+
+// paletteBits is the number of bits needed to index n colours, at least 1
+func paletteBits(n int) int {
+	bits := 1
+	for 1<<bits < n {
+		bits++
+	}
+	return bits
+}
+
+// subBlockWriter splits image data into the 255-byte sub-blocks GIF uses
+type subBlockWriter struct {
+	g   *gifWriter
+	buf [255]byte
+	n   int
+}
+
+func (s *subBlockWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.buf[s.n] = b
+		s.n++
+		if s.n == len(s.buf) {
+			s.flush()
+		}
+	}
+	return len(p), s.g.err
+}
+
+func (s *subBlockWriter) flush() {
+	if s.n == 0 {
+		return
+	}
+	s.g.write(byte(s.n))
+	s.g.write(s.buf[:s.n]...)
+	s.n = 0
+}
@@ -0,0 +1,77 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+)
+
+// sameFrame reports whether two quantized frames show the same picture.
+// Runs of identical frames, such as the hold at the end and cursor blinks
+// over unchanged code, are merged into one frame with the summed delay.
+// Comparing after quantization also catches frames that differ only in
+// ways the palette cannot show.
+func sameFrame(a, b *image.Paletted) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	if samePalette(a.Palette, b.Palette) {
+		return bytes.Equal(a.Pix, b.Pix)
+	}
+	sameColor := samePixel(a, b)
+	for i := range a.Pix {
+		if !sameColor(a.Pix[i], b.Pix[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// similarFrames returns a test for quantized frames that differ in less
+// than fraction of their pixels, or nil when fraction is zero
+func similarFrames(fraction float64) func(a, b *image.Paletted) bool {
+	if fraction <= 0 {
+		return nil
+	}
+	return func(a, b *image.Paletted) bool {
+		if a.Bounds() != b.Bounds() {
+			return false
+		}
+		limit := int(fraction * float64(len(a.Pix)))
+		sameColor := samePixel(a, b)
+		changed := 0
+		for i := range a.Pix {
+			if !sameColor(a.Pix[i], b.Pix[i]) {
+				if changed++; changed > limit {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
+
+// similarImages is similarFrames for full-colour frames
+func similarImages(fraction float64) func(a, b *image.RGBA) bool {
+	if fraction <= 0 {
+		return nil
+	}
+	return func(a, b *image.RGBA) bool {
+		if a.Bounds() != b.Bounds() {
+			return false
+		}
+		size := a.Bounds().Size()
+		limit := int(fraction * float64(size.X*size.Y))
+		changed := 0
+		for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+			ra, rb := rowPix(a, y), rowPix(b, y)
+			for i := 0; i < len(ra); i += 4 {
+				if !bytes.Equal(ra[i:i+4], rb[i:i+4]) {
+					if changed++; changed > limit {
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}
+}
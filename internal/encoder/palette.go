@@ -0,0 +1,194 @@
+package encoder
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// maxColors is the size of a GIF colour table
+const maxColors = 256
+
+// maxThemeColors caps the entries reserved for theme colours so there is
+// always room left for anti-aliasing and gradients
+const maxThemeColors = maxColors / 2
+
+// maxSampleFrames and maxSamplePixels bound the work spent building a
+// global palette on long animations
+const (
+	maxSampleFrames = 16
+	maxSamplePixels = 200_000
+)
+
+// colorCount is one distinct colour and how often it was sampled
+type colorCount struct {
+	rgb   [3]uint8
+	count int
+}
+
+// SampleIndices picks up to maxSampleFrames evenly spaced frames out of
+// total for building a global palette, always including the last one
+// since it shows every token
+func SampleIndices(total int) []int {
+	n := min(total, maxSampleFrames)
+	indices := make([]int, n)
+	for i := range indices {
+		if n > 1 {
+			indices[i] = i * (total - 1) / (n - 1)
+		}
+	}
+	return indices
+}
+
+// buildPalette creates an adaptive palette of up to size colours: exact
+// entries for the theme colours, and a median cut of the sampled colours
+// for the rest. Theme colours take at most half of a small palette.
+func buildPalette(counts map[[3]uint8]int, themeColors []color.RGBA, size int) color.Palette {
+	palette := color.Palette{}
+	reserved := map[[3]uint8]bool{}
+	for _, c := range themeColors {
+		rgb := [3]uint8{c.R, c.G, c.B}
+		if reserved[rgb] || len(palette) == min(maxThemeColors, size/2) {
+			continue
+		}
+		reserved[rgb] = true
+		palette = append(palette, color.RGBA{c.R, c.G, c.B, 255})
+	}
+
+	// Pixels matching a theme colour are already represented exactly
+	var histogram []colorCount
+	for rgb, count := range counts {
+		if !reserved[rgb] {
+			histogram = append(histogram, colorCount{rgb, count})
+		}
+	}
+
+	// Map order is random; sorting keeps the palette the same from run to run
+	sort.Slice(histogram, func(i, j int) bool {
+		a, b := histogram[i].rgb, histogram[j].rgb
+		return a[0] < b[0] || (a[0] == b[0] && (a[1] < b[1] || (a[1] == b[1] && a[2] < b[2])))
+	})
+
+	for _, rgb := range medianCut(histogram, size-len(palette)) {
+		palette = append(palette, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+	}
+	return palette
+}
+
+// addSample counts the colours of up to limit pixels spread over a frame.
+// Transparent margins count as black, which is how they are drawn.
+func addSample(counts map[[3]uint8]int, frame *image.RGBA, limit int) {
+	pixels := len(frame.Pix) / 4
+	step := max(1, pixels/max(1, limit))
+	for p := 0; p < pixels; p += step {
+		i := p * 4
+		counts[[3]uint8{frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2]}]++
+	}
+}
+
+// colorBox is a set of colours that will share one palette entry
+type colorBox struct {
+	colors []colorCount
+	weight int
+	lo, hi [3]uint8
+}
+
+func newColorBox(colors []colorCount) *colorBox {
+	b := &colorBox{colors: colors, lo: [3]uint8{255, 255, 255}}
+	for _, c := range colors {
+		b.weight += c.count
+		for ch := 0; ch < 3; ch++ {
+			b.lo[ch] = min(b.lo[ch], c.rgb[ch])
+			b.hi[ch] = max(b.hi[ch], c.rgb[ch])
+		}
+	}
+	return b
+}
+
+// widest returns the channel with the largest range and that range
+func (b *colorBox) widest() (int, int) {
+	channel, width := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		if w := int(b.hi[ch]) - int(b.lo[ch]); w > width {
+			channel, width = ch, w
+		}
+	}
+	return channel, width
+}
+
+// representative is the colour the box maps to. A colour holding most of
+// the box's weight is kept exactly, otherwise the weighted mean is used.
+func (b *colorBox) representative() [3]uint8 {
+	var sum [3]int
+	top := b.colors[0]
+	for _, c := range b.colors {
+		for ch := 0; ch < 3; ch++ {
+			sum[ch] += int(c.rgb[ch]) * c.count
+		}
+		if c.count > top.count {
+			top = c
+		}
+	}
+	if top.count*2 >= b.weight {
+		return top.rgb
+	}
+	var mean [3]uint8
+	for ch := 0; ch < 3; ch++ {
+		mean[ch] = uint8((sum[ch] + b.weight/2) / b.weight)
+	}
+	return mean
+}
+
+// medianCut reduces a histogram to at most n colours by repeatedly
+// splitting the box with the most weighted spread at its weighted median
+func medianCut(histogram []colorCount, n int) [][3]uint8 {
+	if n <= 0 || len(histogram) == 0 {
+		return nil
+	}
+	if len(histogram) <= n {
+		out := make([][3]uint8, len(histogram))
+		for i, c := range histogram {
+			out[i] = c.rgb
+		}
+		return out
+	}
+
+	boxes := []*colorBox{newColorBox(histogram)}
+	for len(boxes) < n {
+		best, bestScore := -1, 0
+		for i, b := range boxes {
+			if len(b.colors) < 2 {
+				continue
+			}
+			_, width := b.widest()
+			if score := width * b.weight; score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		b := boxes[best]
+		ch, _ := b.widest()
+		sort.SliceStable(b.colors, func(i, j int) bool { return b.colors[i].rgb[ch] < b.colors[j].rgb[ch] })
+
+		// Cut where half the weight is on each side, keeping both halves non-empty
+		cut, acc := 1, 0
+		for i, c := range b.colors[:len(b.colors)-1] {
+			acc += c.count
+			cut = i + 1
+			if acc*2 >= b.weight {
+				break
+			}
+		}
+		boxes[best] = newColorBox(b.colors[:cut])
+		boxes = append(boxes, newColorBox(b.colors[cut:]))
+	}
+
+	out := make([][3]uint8, len(boxes))
+	for i, b := range boxes {
+		out[i] = b.representative()
+	}
+	return out
+}
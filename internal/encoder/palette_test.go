@@ -0,0 +1,123 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+// gradientCounts is a histogram of many colours, weighted unevenly so
+// median cut has real choices to make
+func gradientCounts() map[[3]uint8]int {
+	counts := map[[3]uint8]int{}
+	for r := 0; r < 256; r += 16 {
+		for g := 0; g < 256; g += 16 {
+			for b := 0; b < 256; b += 32 {
+				counts[[3]uint8{uint8(r), uint8(g), uint8(b)}] = 1 + (r+g+b)%7
+			}
+		}
+	}
+	return counts
+}
+
+// themeColors returns n distinct colours that are not in gradientCounts
+func themeColors(n int) []color.RGBA {
+	out := make([]color.RGBA, n)
+	for i := range out {
+		out[i] = color.RGBA{uint8(1 + 2*i), uint8(255 - i), 0x35, 0xff}
+	}
+	return out
+}
+
+func TestBuildPalette(t *testing.T) {
+	tests := []struct {
+		size     int
+		themes   []color.RGBA
+		reserved int // Theme colours expected to get exact entries
+	}{
+		{2, nil, 0},
+		{2, themeColors(3), 1},
+		{8, themeColors(3), 3},
+		{8, themeColors(6), 4},
+		{16, append(themeColors(2), themeColors(2)...), 2}, // Duplicates take one entry
+		{256, themeColors(10), 10},
+		{256, themeColors(200), maxThemeColors},
+	}
+	for _, tt := range tests {
+		palette := buildPalette(gradientCounts(), tt.themes, tt.size)
+		if len(palette) > tt.size {
+			t.Errorf("size %d, %d theme colours: palette has %d entries", tt.size, len(tt.themes), len(palette))
+		}
+		if len(palette) < tt.size {
+			t.Errorf("size %d, %d theme colours: palette has only %d entries for %d sampled colours", tt.size, len(tt.themes), len(palette), len(gradientCounts()))
+		}
+		for i, c := range tt.themes[:tt.reserved] {
+			if !paletteHas(palette, c) {
+				t.Errorf("size %d, %d theme colours: theme colour %d %v is missing", tt.size, len(tt.themes), i, c)
+			}
+		}
+	}
+}
+
+func TestMedianCut(t *testing.T) {
+	var histogram []colorCount
+	for rgb, count := range gradientCounts() {
+		histogram = append(histogram, colorCount{rgb, count})
+	}
+	for _, n := range []int{-1, 0, 1, 2, 7, 255, len(histogram), len(histogram) + 1} {
+		got := medianCut(histogram, n)
+		if want := max(0, min(n, len(histogram))); len(got) != want {
+			t.Errorf("medianCut(%d colours, %d) = %d colours, want %d", len(histogram), n, len(got), want)
+		}
+	}
+	if got := medianCut(nil, 4); len(got) != 0 {
+		t.Errorf("medianCut(nil, 4) = %d colours, want 0", len(got))
+	}
+}
+
+func TestGIFKeepsThemeColors(t *testing.T) {
+	// A noisy gradient with small patches of theme colour, too few pixels
+	// to survive median cut on their own
+	frame := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			frame.SetRGBA(x, y, color.RGBA{uint8(4 * x), uint8(4 * y), uint8(x * y), 0xff})
+		}
+	}
+	themes := themeColors(3)
+	for i, c := range themes {
+		draw.Draw(frame, image.Rect(8*i, 0, 8*i+2, 2), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	for _, palette := range []string{PaletteGlobal, PaletteLocal} {
+		for _, dither := range []string{DitherNone, DitherFloydSteinberg, DitherOrdered} {
+			data := encodeFile(t, FormatGIF, []*image.RGBA{frame}, Options{Palette: palette, Dither: dither, Colors: 8, ThemeColors: themes})
+			g, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%s, %s: %v", palette, dither, err)
+			}
+			img := g.Image[0]
+			if len(img.Palette) > 8 {
+				t.Errorf("%s, %s: %d colours, want at most 8", palette, dither, len(img.Palette))
+			}
+			for i, c := range themes {
+				if got := color.RGBAModel.Convert(img.At(8*i, 0)).(color.RGBA); got != c {
+					t.Errorf("%s, %s: theme colour %d drawn as %v, want %v", palette, dither, i, got, c)
+				}
+			}
+		}
+	}
+}
+
+// paletteHas reports whether c is an exact entry of p
+func paletteHas(p color.Palette, c color.RGBA) bool {
+	for _, entry := range p {
+		if color.RGBAModel.Convert(entry).(color.RGBA) == c {
+			return true
+		}
+	}
+	return false
+}
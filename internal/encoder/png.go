@@ -0,0 +1,237 @@
+package encoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// PNGEncoder writes a single still image: the last frame it is given.
+// Callers usually give it only the finished frame.
+type PNGEncoder struct {
+	out   *countingWriter
+	last  *image.RGBA
+	stats Stats
+}
+
+// NewPNGEncoder creates an encoder writing a still PNG to w
+func NewPNGEncoder(w io.Writer, opts Options) (*PNGEncoder, error) {
+	return &PNGEncoder{out: &countingWriter{w: w}}, nil
+}
+
+// Sample does nothing: PNG frames are not quantized
+func (e *PNGEncoder) Sample(*image.RGBA) {}
+
+// WriteFrame replaces the frame to be written
+func (e *PNGEncoder) WriteFrame(frame *image.RGBA) error {
+	e.last = frame
+	return nil
+}
+
+// Close writes the last frame
+func (e *PNGEncoder) Close() (*Stats, error) {
+	if e.last == nil {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	if err := png.Encode(e.out, e.last); err != nil {
+		return nil, err
+	}
+	e.stats.Frames = 1
+	e.stats.Bytes = e.out.n
+	stats := e.stats
+	return &stats, nil
+}
+
+// EncodePNG writes img to a PNG file
+func EncodePNG(img image.Image, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// FramesManifest is the JSON timing manifest written next to a PNG frame
+// sequence. Times are in milliseconds from the start of the animation.
+type FramesManifest struct {
+	FPS      int             `json:"fps"`
+	Width    int             `json:"width"`
+	Height   int             `json:"height"`
+	Plays    int             `json:"plays"` // 0 means forever
+	Duration int             `json:"duration_ms"`
+	Frames   []ManifestFrame `json:"frames"`
+}
+
+// ManifestFrame is one file of a PNG frame sequence
+type ManifestFrame struct {
+	File     string `json:"file"`
+	Start    int    `json:"start_ms"`
+	Duration int    `json:"duration_ms"`
+}
+
+// manifestName is the manifest's file name in a frame sequence directory
+const manifestName = "frames.json"
+
+// FramesEncoder writes every frame as a numbered PNG in a directory, with
+// a manifest of when each is shown. Identical consecutive frames are
+// written once with the summed duration. Unlike the animated formats no
+// frames are dropped, so times are exact to the millisecond.
+type FramesEncoder struct {
+	dir      string
+	opts     Options
+	manifest FramesManifest
+
+	index   int         // Frames received
+	pending *image.RGBA // Frame waiting for its final duration
+	start   int         // Index of the pending frame's first appearance
+	stats   Stats
+}
+
+// NewFramesEncoder creates an encoder writing a PNG frame sequence into
+// dir, creating it if needed. The frames listed in the manifest of an
+// earlier run are removed; any other non-empty directory is refused, so
+// files this tool did not write are never touched.
+func NewFramesEncoder(dir string, opts Options) (*FramesEncoder, error) {
+	if err := clearFrames(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FramesEncoder{
+		dir:  dir,
+		opts: opts,
+		manifest: FramesManifest{
+			FPS:    opts.FPS,
+			Plays:  Plays(opts.LoopCount),
+			Frames: []ManifestFrame{},
+		},
+	}, nil
+}
+
+// clearFrames removes the frames an earlier run listed in dir's manifest.
+// A directory without a manifest must be empty or missing.
+func clearFrames(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("%s is not empty and has no %s from an earlier run - choose a new or empty directory", dir, manifestName)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var old FramesManifest
+	if err := json.Unmarshal(data, &old); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Join(dir, manifestName), err)
+	}
+	for _, frame := range old.Frames {
+		// Only plain frame names, never paths out of dir
+		if ok, _ := filepath.Match("frame_*.png", frame.File); !ok || filepath.Base(frame.File) != frame.File {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, frame.File)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sample does nothing: PNG frames are not quantized
+func (e *FramesEncoder) Sample(*image.RGBA) {}
+
+// WriteFrame adds the next frame. It is written once the following frame
+// shows whether it is a duplicate.
+func (e *FramesEncoder) WriteFrame(frame *image.RGBA) error {
+	defer func() { e.index++ }()
+	if e.pending != nil && sameImage(e.pending, frame) {
+		e.stats.Duplicates++
+		return nil
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	e.pending, e.start = frame, e.index
+	return nil
+}
+
+// Close writes the last frame and the manifest
+func (e *FramesEncoder) Close() (*Stats, error) {
+	if err := e.flush(); err != nil {
+		return nil, err
+	}
+	if e.stats.Frames == 0 {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	e.manifest.Duration = e.millis(e.index)
+
+	data, err := json.MarshalIndent(e.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(filepath.Join(e.dir, manifestName), data, 0o644); err != nil {
+		return nil, err
+	}
+	e.stats.Bytes += int64(len(data))
+	stats := e.stats
+	return &stats, nil
+}
+
+// flush writes the pending frame, which lasts until the current one
+func (e *FramesEncoder) flush() error {
+	if e.pending == nil {
+		return nil
+	}
+	frame := e.pending
+	e.pending = nil
+
+	name := fmt.Sprintf("frame_%05d.png", e.stats.Frames+1)
+	path := filepath.Join(e.dir, name)
+	if err := EncodePNG(frame, path); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if e.stats.Frames == 0 {
+		b := frame.Bounds()
+		e.manifest.Width, e.manifest.Height = b.Dx(), b.Dy()
+	}
+	start, end := e.millis(e.start), e.millis(e.index)
+	e.manifest.Frames = append(e.manifest.Frames, ManifestFrame{
+		File:     name,
+		Start:    start,
+		Duration: end - start,
+	})
+	e.stats.Frames++
+	e.stats.Bytes += info.Size()
+	return nil
+}
+
+// millis is when frame i starts, in whole milliseconds
+func (e *FramesEncoder) millis(i int) int {
+	return int(math.Round(float64(i) * 1000 / float64(e.opts.FPS)))
+}
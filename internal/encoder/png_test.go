@@ -0,0 +1,39 @@
+package encoder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFramesEncoderClearsOnlyItsFrames(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A directory this tool did not write to is refused
+	write("frame_00001.png")
+	if _, err := NewFramesEncoder(dir, Options{FPS: 10}); err == nil {
+		t.Fatal("NewFramesEncoder accepted a non-empty directory without a manifest")
+	}
+
+	// With a manifest, only the frames it lists go
+	write("frame_00002.png")
+	write("notes.txt")
+	manifest := `{"frames": [{"file": "frame_00001.png"}, {"file": "../notes.txt"}, {"file": "notes.txt"}]}`
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFramesEncoder(dir, Options{FPS: 10}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"frame_00001.png": false, "frame_00002.png": true, "notes.txt": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists: %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// decodedFrame is one frame read back from an encoded animation
+type decodedFrame struct {
+	img   image.Image
+	rect  image.Rectangle // Where it goes on the canvas
+	over  bool            // Alpha blended rather than replacing the region
+	delay int             // Centiseconds
+}
+
+// testFrames returns distinct frames that change in small, oddly placed
+// regions. With alpha, some pixels are transparent or translucent.
+func testFrames(alpha bool) []*image.RGBA {
+	bg := color.RGBA{0x10, 0x20, 0x30, 0xff}
+	base := image.NewRGBA(image.Rect(0, 0, 48, 32))
+	draw.Draw(base, base.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	if alpha {
+		base.SetRGBA(0, 0, color.RGBA{})
+	}
+
+	var frames []*image.RGBA
+	next := func(r image.Rectangle, c color.RGBA) {
+		img := image.NewRGBA(base.Bounds())
+		copy(img.Pix, frames[len(frames)-1].Pix)
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+		frames = append(frames, img)
+	}
+	frames = append(frames, base)
+	next(image.Rect(5, 3, 12, 9), color.RGBA{0xe0, 0x30, 0x30, 0xff})
+	next(image.Rect(21, 13, 30, 20), color.RGBA{0x30, 0xe0, 0x30, 0xff})
+	if alpha {
+		next(image.Rect(41, 25, 43, 27), color.RGBA{0x40, 0x40, 0, 0x80})
+	}
+	next(image.Rect(21, 13, 30, 20), bg)
+	next(image.Rect(33, 1, 34, 2), color.RGBA{0xf0, 0xf0, 0xf0, 0xff})
+	return frames
+}
+
+// encodeFile runs frames through the named encoder at 10fps and returns
+// the file it wrote
+func encodeFile(t *testing.T, name string, frames []*image.RGBA, opts Options) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out."+name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	opts.FPS = 10
+	enc, err := New(f, name, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		enc.Sample(frame)
+	}
+	for _, frame := range frames {
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := enc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Frames != len(frames) {
+		t.Errorf("wrote %d frames, want %d", stats.Frames, len(frames))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != stats.Bytes {
+		t.Errorf("file is %d bytes, stats say %d", len(data), stats.Bytes)
+	}
+	return data
+}
+
+// checkComposite draws decoded frames onto a transparent canvas in turn
+// and compares each result with the frame that was encoded
+func checkComposite(t *testing.T, decoded []decodedFrame, frames []*image.RGBA) {
+	t.Helper()
+	if len(decoded) != len(frames) {
+		t.Fatalf("decoded %d frames, want %d", len(decoded), len(frames))
+	}
+	canvas := image.NewRGBA(frames[0].Bounds())
+	for i, d := range decoded {
+		op := draw.Src
+		if d.over {
+			op = draw.Over
+		}
+		draw.Draw(canvas, d.rect, d.img, d.img.Bounds().Min, op)
+		if d.delay != 10 {
+			t.Errorf("frame %d: delay %dcs, want 10cs", i, d.delay)
+		}
+		if x, y, ok := samePixels(canvas, frames[i]); !ok {
+			t.Errorf("frame %d: pixel (%d, %d) is %v, want %v", i, x, y, canvas.At(x, y), frames[i].At(x, y))
+		}
+	}
+}
+
+// samePixels compares two images, allowing the rounding straight alpha
+// storage adds to translucent pixels. It returns the first pixel that
+// differs.
+func samePixels(a, b *image.RGBA) (x, y int, ok bool) {
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			i := a.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				if abs(int(a.Pix[i+c])-int(b.Pix[i+c])) > 1 {
+					return x, y, false
+				}
+			}
+		}
+	}
+	return 0, 0, true
+}
+
+func TestGIFRoundTrip(t *testing.T) {
+	frames := testFrames(false)
+	for _, delta := range []bool{false, true} {
+		data := encodeFile(t, FormatGIF, frames, Options{Palette: PaletteGlobal, Dither: DitherNone, Delta: delta})
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("delta %v: %v", delta, err)
+		}
+		if g.LoopCount != LoopForever {
+			t.Errorf("delta %v: loop count %d, want %d", delta, g.LoopCount, LoopForever)
+		}
+		decoded := make([]decodedFrame, len(g.Image))
+		for i, img := range g.Image {
+			if g.Disposal[i] != gif.DisposalNone {
+				t.Errorf("delta %v: frame %d has disposal %d", delta, i, g.Disposal[i])
+			}
+			decoded[i] = decodedFrame{img: img, rect: img.Bounds(), over: true, delay: g.Delay[i]}
+		}
+		checkComposite(t, decoded, frames)
+	}
+}
+
+func TestAPNGRoundTrip(t *testing.T) {
+	frames := testFrames(true)
+	for _, delta := range []bool{false, true} {
+		data := encodeFile(t, FormatAPNG, frames, Options{LoopCount: LoopOnce, Delta: delta})
+		checkComposite(t, decodeAPNG(t, data, len(frames)), frames)
+	}
+}
+
+// decodeAPNG checks the chunk CRCs, sequence numbers and animation
+// control of an APNG, then decodes each frame as a PNG of its own
+func decodeAPNG(t *testing.T, data []byte, frames int) []decodedFrame {
+	t.Helper()
+	if !bytes.HasPrefix(data, pngSignature) {
+		t.Fatal("missing PNG signature")
+	}
+	var ihdr, idat []byte
+	var decoded []decodedFrame
+	var seq uint32
+	var fctl []byte
+	flush := func() {
+		if fctl == nil {
+			return
+		}
+		w, h := binary.BigEndian.Uint32(fctl[4:]), binary.BigEndian.Uint32(fctl[8:])
+		x, y := int(binary.BigEndian.Uint32(fctl[12:])), int(binary.BigEndian.Uint32(fctl[16:]))
+		num, den := binary.BigEndian.Uint16(fctl[20:]), binary.BigEndian.Uint16(fctl[22:])
+		if fctl[24] != apngDisposeNone {
+			t.Errorf("frame %d: dispose op %d", len(decoded), fctl[24])
+		}
+
+		head := append(binary.BigEndian.AppendUint32(nil, w), binary.BigEndian.AppendUint32(nil, h)...)
+		var still bytes.Buffer
+		still.Write(pngSignature)
+		still.Write(pngChunk("IHDR", append(head, ihdr[8:]...)))
+		still.Write(pngChunk("IDAT", idat))
+		still.Write(pngChunk("IEND", nil))
+		img, err := png.Decode(&still)
+		if err != nil {
+			t.Fatalf("frame %d: %v", len(decoded), err)
+		}
+		decoded = append(decoded, decodedFrame{
+			img:   img,
+			rect:  image.Rect(x, y, x+int(w), y+int(h)),
+			over:  fctl[25] == apngBlendOver,
+			delay: int(num) * 100 / int(den),
+		})
+		fctl, idat = nil, nil
+	}
+
+	var actl []byte
+	for rest := data[len(pngSignature):]; len(rest) > 0; {
+		n := int(binary.BigEndian.Uint32(rest))
+		typ, body := string(rest[4:8]), rest[8:8+n]
+		if got, want := binary.BigEndian.Uint32(rest[8+n:]), crc32.ChecksumIEEE(rest[4:8+n]); got != want {
+			t.Errorf("%s chunk: CRC %08x, want %08x", typ, got, want)
+		}
+		rest = rest[12+n:]
+
+		switch typ {
+		case "IHDR":
+			ihdr = body
+		case "acTL":
+			actl = body
+		case "fcTL", "fdAT":
+			if got := binary.BigEndian.Uint32(body); got != seq {
+				t.Errorf("%s chunk: sequence number %d, want %d", typ, got, seq)
+			}
+			seq++
+			if typ == "fcTL" {
+				flush()
+				fctl = body
+			} else {
+				idat = append(idat, body[4:]...)
+			}
+		case "IDAT":
+			idat = append(idat, body...)
+		case "IEND":
+			flush()
+			if len(rest) > 0 {
+				t.Errorf("%d bytes after IEND", len(rest))
+			}
+		}
+	}
+
+	if actl == nil {
+		t.Fatal("missing acTL chunk")
+	}
+	if got := int(binary.BigEndian.Uint32(actl)); got != frames || len(decoded) != frames {
+		t.Errorf("acTL says %d frames and %d were decoded, want %d", got, len(decoded), frames)
+	}
+	if got := binary.BigEndian.Uint32(actl[4:]); got != 1 {
+		t.Errorf("acTL says %d plays, want 1", got)
+	}
+	return decoded
+}
+
+// pngChunk builds a PNG chunk with its length and CRC
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, typ...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestWebPRoundTrip(t *testing.T) {
+	frames := testFrames(true)
+	for _, delta := range []bool{false, true} {
+		data := encodeFile(t, FormatWebP, frames, Options{LoopCount: 2, Delta: delta})
+		checkComposite(t, decodeWebP(t, data), frames)
+	}
+}
+
+// decodeWebP checks the RIFF container of an animated WebP, then decodes
+// each frame's VP8L bitstream as a WebP of its own
+func decodeWebP(t *testing.T, data []byte) []decodedFrame {
+	t.Helper()
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatal("missing RIFF WEBP header")
+	}
+	if got := int(binary.LittleEndian.Uint32(data[4:])); got != len(data)-8 {
+		t.Errorf("RIFF size %d, want %d", got, len(data)-8)
+	}
+
+	var decoded []decodedFrame
+	for rest := data[12:]; len(rest) > 0; {
+		n := int(binary.LittleEndian.Uint32(rest[4:]))
+		typ, body := string(rest[:4]), rest[8:8+n]
+		rest = rest[8+n+n%2:]
+
+		switch typ {
+		case "VP8X":
+			if body[0]&webpFlagAnimation == 0 {
+				t.Error("VP8X chunk lacks the animation flag")
+			}
+			if body[0]&webpFlagAlpha == 0 {
+				t.Error("VP8X chunk lacks the alpha flag")
+			}
+		case "ANIM":
+			if got := binary.LittleEndian.Uint16(body[4:]); got != 3 {
+				t.Errorf("ANIM says %d plays, want 3", got)
+			}
+		case "ANMF":
+			x, y := 2*uint24(body[0:]), 2*uint24(body[3:])
+			w, h := uint24(body[6:])+1, uint24(body[9:])+1
+			if string(body[16:20]) != "VP8L" {
+				t.Fatalf("frame %d: %q bitstream, want VP8L", len(decoded), body[16:20])
+			}
+			vp8l := body[16 : 24+binary.LittleEndian.Uint32(body[20:])]
+			still := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(vp8l)+len(vp8l)%2))...)
+			still = append(append(still, "WEBP"...), vp8l...)
+			still = append(still, make([]byte, len(vp8l)%2)...)
+			img, err := webp.Decode(bytes.NewReader(still))
+			if err != nil {
+				t.Fatalf("frame %d: %v", len(decoded), err)
+			}
+			if img.Bounds().Dx() != w || img.Bounds().Dy() != h {
+				t.Errorf("frame %d: %v bitstream in a %dx%d frame", len(decoded), img.Bounds(), w, h)
+			}
+			decoded = append(decoded, decodedFrame{
+				img:   img,
+				rect:  image.Rect(x, y, x+w, y+h),
+				over:  body[15]&webpNoBlend == 0,
+				delay: uint24(body[12:]) / 10,
+			})
+		}
+	}
+	return decoded
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
@@ -0,0 +1,143 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MinDelay is the shortest frame delay, in hundredths of a second, that
+// browsers honor. Shorter delays are commonly replaced with 10cs, which
+// makes an animation play far slower than intended.
+const MinDelay = 2
+
+// MaxFPS is the highest frame rate a GIF can play at in browsers
+const MaxFPS = 100 / MinDelay
+
+// frameEnd is when frame i (0-based) of an animation at fps ends, in whole
+// hundredths of a second. Rounding the exact end time lets the fractional
+// part carry over, so 30fps alternates 3cs and 4cs delays while the total
+// matches n/fps seconds.
+func frameEnd(i, fps int) int {
+	return int(math.Round(float64(i+1) * 100 / float64(fps)))
+}
+
+// Loop counts for Options.LoopCount, matching image/gif
+const (
+	LoopForever = 0
+	LoopOnce    = -1
+)
+
+// maxPlays is the most plays a GIF can store: its loop count is a 16-bit
+// number of repeats after the first play
+const maxPlays = 1 << 16
+
+// ParseLoop parses a --loop value: "forever", "once" or the number of
+// times to play the animation, and returns the GIF loop count for it
+func ParseLoop(s string) (int, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	switch value {
+	case "", "forever", "infinite":
+		return LoopForever, nil
+	case "once":
+		return LoopOnce, nil
+	}
+	plays, err := strconv.Atoi(value)
+	if err != nil || plays < 1 || plays > maxPlays {
+		return 0, fmt.Errorf("invalid loop %q (want forever, once or a number of plays from 1 to %d)", s, maxPlays)
+	}
+	if plays == 1 {
+		return LoopOnce, nil
+	}
+	// The GIF loop count is the number of repeats after the first play
+	return plays - 1, nil
+}
+
+// Plays converts a GIF loop count to the number of plays APNG, WebP and
+// SVG store, where 0 means forever
+func Plays(loopCount int) int {
+	switch {
+	case loopCount == LoopForever:
+		return 0
+	case loopCount < 0:
+		return 1
+	}
+	return loopCount + 1
+}
+
+// timeline gives frames arriving at a fixed rate their delays. Runs of
+// identical frames are merged into one frame with the summed delay, and
+// frames shown for less than MinDelay are dropped with their time handed
+// to the next one, so playback is as fast as browsers allow without
+// changing the total duration; the final frame is stretched to MinDelay
+// rather than lost. Every format shares it so they all play alike.
+//
+// With similar set, frames close enough to the first frame of the pending
+// run are merged too. The newest of them replaces the pending frame, so
+// the run shows its final state early rather than its first state late,
+// and the finished code is never lost to a merge.
+type timeline[F any] struct {
+	fps     int
+	same    func(a, b F) bool
+	similar func(a, b F) bool              // Optional, see Options.MergeBelow
+	write   func(frame F, delay int) error // Delay in centiseconds
+	stats   *Stats
+
+	index   int  // Frames received
+	elapsed int  // Centiseconds of animation received so far
+	pending F    // Frame waiting for its final delay
+	anchor  F    // First frame of the pending run
+	waiting bool // Whether pending holds a frame
+	delay   int  // Delay of the pending frame so far
+	carry   int  // Delay of a dropped frame, handed to the next one
+}
+
+// add appends the next frame. The frame is written once the following
+// frame shows whether it is a duplicate.
+func (t *timeline[F]) add(frame F) error {
+	end := frameEnd(t.index, t.fps)
+	delay := end - t.elapsed
+	t.index++
+	t.elapsed = end
+
+	if t.waiting && t.same(t.pending, frame) {
+		t.delay += delay
+		t.stats.Duplicates++
+		return nil
+	}
+	if t.waiting && t.similar != nil && t.similar(t.anchor, frame) {
+		t.pending = frame
+		t.delay += delay
+		t.stats.Merged++
+		return nil
+	}
+	if err := t.flush(false); err != nil {
+		return err
+	}
+	t.pending, t.anchor, t.waiting = frame, frame, true
+	t.delay = delay + t.carry
+	t.carry = 0
+	return nil
+}
+
+// flush writes the pending frame; final marks the end of the animation
+func (t *timeline[F]) flush(final bool) error {
+	if !t.waiting {
+		return nil
+	}
+	frame, delay := t.pending, t.delay
+	var zero F
+	t.pending, t.anchor, t.waiting = zero, zero, false
+
+	if delay < MinDelay {
+		if !final {
+			t.carry = delay
+			t.stats.Dropped++
+			return nil
+		}
+		delay = MinDelay
+	}
+	t.stats.Frames++
+	return t.write(frame, delay)
+}
@@ -0,0 +1,188 @@
+package encoder
+
+import "testing"
+
+// written is one frame a timeline wrote
+type written struct {
+	frame, delay int
+}
+
+// runTimeline feeds frames through a timeline at fps and returns what it
+// wrote and its stats
+func runTimeline(t *testing.T, fps int, frames []int, similar func(a, b int) bool) ([]written, Stats) {
+	t.Helper()
+	var out []written
+	var stats Stats
+	tl := timeline[int]{
+		fps:     fps,
+		same:    func(a, b int) bool { return a == b },
+		similar: similar,
+		write: func(frame, delay int) error {
+			out = append(out, written{frame, delay})
+			return nil
+		},
+		stats: &stats,
+	}
+	for _, f := range frames {
+		if err := tl.add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tl.flush(true); err != nil {
+		t.Fatal(err)
+	}
+	return out, stats
+}
+
+// totalDelay sums the delays of written frames
+func totalDelay(out []written) int {
+	total := 0
+	for _, w := range out {
+		total += w.delay
+	}
+	return total
+}
+
+// distinct returns n frames that all differ
+func distinct(n int) []int {
+	frames := make([]int, n)
+	for i := range frames {
+		frames[i] = i
+	}
+	return frames
+}
+
+func TestFrameEnd30FPS(t *testing.T) {
+	// A third of 10cs per frame: 3cs and 4cs alternate around the exact time
+	want := []int{3, 4, 3, 3, 4, 3}
+	prev := 0
+	for i, d := range want {
+		end := frameEnd(i, 30)
+		if got := end - prev; got != d {
+			t.Errorf("frame %d: delay %dcs, want %dcs", i, got, d)
+		}
+		prev = end
+	}
+	if got := frameEnd(29, 30); got != 100 {
+		t.Errorf("30 frames at 30fps end at %dcs, want 100cs", got)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	similar := func(a, b int) bool { return b-a <= 1 }
+	tests := []struct {
+		name    string
+		fps     int
+		frames  []int
+		similar func(a, b int) bool
+		want    []written
+		stats   Stats
+	}{
+		{
+			name:   "30fps alternates 3cs and 4cs",
+			fps:    30,
+			frames: distinct(6),
+			want:   []written{{0, 3}, {1, 4}, {2, 3}, {3, 3}, {4, 4}, {5, 3}},
+			stats:  Stats{Frames: 6},
+		},
+		{
+			name:   "duplicates merge into one delay",
+			fps:    10,
+			frames: []int{7, 7, 7, 8, 8},
+			want:   []written{{7, 30}, {8, 20}},
+			stats:  Stats{Frames: 2, Duplicates: 3},
+		},
+		{
+			name:   "short frames hand their time to the next",
+			fps:    100,
+			frames: distinct(4),
+			want:   []written{{1, 2}, {3, 2}},
+			stats:  Stats{Frames: 2, Dropped: 2},
+		},
+		{
+			name:   "the last frame is stretched rather than dropped",
+			fps:    100,
+			frames: distinct(3),
+			want:   []written{{1, 2}, {2, 2}},
+			stats:  Stats{Frames: 2, Dropped: 1},
+		},
+		{
+			name:    "similar frames keep the newest content",
+			fps:     10,
+			frames:  []int{0, 1, 2, 3, 3},
+			similar: similar,
+			want:    []written{{1, 20}, {3, 30}},
+			stats:   Stats{Frames: 2, Merged: 2, Duplicates: 1},
+		},
+		{
+			name:    "similarity is measured from the start of the run",
+			fps:     10,
+			frames:  []int{0, 1, 2},
+			similar: similar,
+			want:    []written{{1, 20}, {2, 10}},
+			stats:   Stats{Frames: 2, Merged: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, stats := runTimeline(t, tt.fps, tt.frames, tt.similar)
+			if len(out) != len(tt.want) {
+				t.Fatalf("wrote %v, want %v", out, tt.want)
+			}
+			for i := range out {
+				if out[i] != tt.want[i] {
+					t.Errorf("wrote %v, want %v", out, tt.want)
+					break
+				}
+			}
+			if stats != tt.stats {
+				t.Errorf("stats %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestTimelineKeepsDuration(t *testing.T) {
+	for _, fps := range []int{10, 24, 30, 60, 100} {
+		frames := distinct(3 * fps)
+		// Every third frame repeats the one before, so merging and dropping mix
+		for i := 1; i < len(frames); i += 3 {
+			frames[i] = frames[i-1]
+		}
+		out, _ := runTimeline(t, fps, frames, nil)
+		// Stretching the last frame to MinDelay may add a little
+		if got := totalDelay(out); got < 300 || got >= 300+MinDelay {
+			t.Errorf("%dfps: 3s of frames play for %dcs, want 300cs", fps, got)
+		}
+		for _, w := range out {
+			if w.delay < MinDelay {
+				t.Errorf("%dfps: frame %d has delay %dcs, below %dcs", fps, w.frame, w.delay, MinDelay)
+			}
+		}
+	}
+}
+
+func TestParseLoop(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		err  bool
+	}{
+		{"", LoopForever, false},
+		{" Forever ", LoopForever, false},
+		{"once", LoopOnce, false},
+		{"1", LoopOnce, false},
+		{" 3", 2, false},
+		{"65536", 65535, false},
+		{"65537", 0, true},
+		{"0", 0, true},
+		{"-2", 0, true},
+		{"twice", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLoop(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseLoop(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package encoder
+
+import (
+	"image"
+	"math/bits"
+	"sort"
+)
+
+// VP8L bitstream constants, from the WebP lossless specification
+const (
+	vp8lMagic         = 0x2f
+	vp8lLiterals      = 256
+	vp8lLengthCodes   = 24
+	vp8lDistanceCodes = 40
+	vp8lSubtractGreen = 2 // Transform type
+
+	vp8lCacheBits     = 10         // Colour cache of 1024 entries
+	vp8lCacheMultiply = 0x1e35a7bd // Colour cache hash multiplier
+	vp8lMaxLength     = 4096       // Longest backward reference
+	vp8lMaxDistance   = 1<<20 - 120
+	vp8lMinMatch      = 3
+	vp8lHashBits      = 16
+	vp8lMaxChain      = 32 // Candidates tried per pixel
+	vp8lMaxCodeBits   = 15
+	vp8lMaxCLCodeBits = 7 // Longest code in a code length code
+)
+
+// vp8lDistanceMap lists the two-dimensional offsets that the 120 shortest
+// distance codes stand for, as (y << 4) | (8 - x)
+var vp8lDistanceMap = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// vp8lCodeLengthOrder is the order code length code lengths are stored in
+var vp8lCodeLengthOrder = [19]uint8{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lEncoder compresses images into the lossless VP8L bitstream WebP
+// uses. It applies the subtract-green transform, then codes pixels as
+// literals, colour cache hits or LZ77 backward references (which cheaply
+// reach the pixels just above), with one set of Huffman codes per image.
+// Buffers are reused from one image to the next.
+type vp8lEncoder struct {
+	argb   []uint32
+	head   []int32
+	chain  []int32
+	tokens []vp8lToken
+	bw     bitWriter
+}
+
+// vp8lToken is one step of the coded pixel stream
+type vp8lToken struct {
+	kind  uint8  // vp8lLiteral, vp8lCache or vp8lCopy
+	value uint32 // ARGB pixel, cache index or copy length
+	dist  uint32 // Distance code of a copy
+}
+
+const (
+	vp8lLiteral = iota
+	vp8lCache
+	vp8lCopy
+)
+
+// encode compresses the rect region of img. The returned bytes are only
+// valid until the next call. alpha reports whether any pixel is not
+// opaque.
+func (e *vp8lEncoder) encode(img *image.RGBA, rect image.Rectangle) (data []byte, alpha bool) {
+	w, h := rect.Dx(), rect.Dy()
+	e.pixels(img, rect)
+	for _, p := range e.argb {
+		if p>>24 != 0xff {
+			alpha = true
+			break
+		}
+	}
+	subtractGreen(e.argb)
+	e.tokenize(w)
+
+	// Histograms of the five alphabets
+	cacheSize := 1 << vp8lCacheBits
+	green := make([]uint32, vp8lLiterals+vp8lLengthCodes+cacheSize)
+	red := make([]uint32, vp8lLiterals)
+	blue := make([]uint32, vp8lLiterals)
+	alphas := make([]uint32, vp8lLiterals)
+	dists := make([]uint32, vp8lDistanceCodes)
+	for _, t := range e.tokens {
+		switch t.kind {
+		case vp8lLiteral:
+			green[t.value>>8&0xff]++
+			red[t.value>>16&0xff]++
+			blue[t.value&0xff]++
+			alphas[t.value>>24]++
+		case vp8lCache:
+			green[vp8lLiterals+vp8lLengthCodes+t.value]++
+		case vp8lCopy:
+			sym, _, _ := prefixEncode(t.value)
+			green[vp8lLiterals+sym]++
+			sym, _, _ = prefixEncode(t.dist)
+			dists[sym]++
+		}
+	}
+
+	bw := &e.bw
+	bw.reset()
+	bw.write(vp8lMagic, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // Version
+
+	bw.write(1, 1) // A transform follows
+	bw.write(vp8lSubtractGreen, 2)
+	bw.write(0, 1) // No more transforms
+
+	bw.write(1, 1) // Colour cache
+	bw.write(vp8lCacheBits, 4)
+	bw.write(0, 1) // One set of codes for the whole image
+
+	greenCode := bw.writeHuffman(green)
+	redCode := bw.writeHuffman(red)
+	blueCode := bw.writeHuffman(blue)
+	alphaCode := bw.writeHuffman(alphas)
+	distCode := bw.writeHuffman(dists)
+
+	for _, t := range e.tokens {
+		switch t.kind {
+		case vp8lLiteral:
+			greenCode.write(bw, t.value>>8&0xff)
+			redCode.write(bw, t.value>>16&0xff)
+			blueCode.write(bw, t.value&0xff)
+			alphaCode.write(bw, t.value>>24)
+		case vp8lCache:
+			greenCode.write(bw, vp8lLiterals+vp8lLengthCodes+t.value)
+		case vp8lCopy:
+			sym, n, extra := prefixEncode(t.value)
+			greenCode.write(bw, vp8lLiterals+sym)
+			bw.write(extra, n)
+			sym, n, extra = prefixEncode(t.dist)
+			distCode.write(bw, sym)
+			bw.write(extra, n)
+		}
+	}
+	bw.flush()
+	return bw.buf, alpha
+}
+
+// pixels loads the rect region of img as straight-alpha ARGB
+func (e *vp8lEncoder) pixels(img *image.RGBA, rect image.Rectangle) {
+	e.argb = e.argb[:0]
+	row := make([]byte, 4*rect.Dx())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		copy(row, img.Pix[i:])
+		unpremultiply(row)
+		for x := 0; x < len(row); x += 4 {
+			e.argb = append(e.argb, uint32(row[x+3])<<24|uint32(row[x])<<16|uint32(row[x+1])<<8|uint32(row[x+2]))
+		}
+	}
+}
+
+// subtractGreen stores red and blue as differences from green, which
+// removes most of the correlation between channels
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := p >> 8 & 0xff
+		r := (p>>16 - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+// tokenize turns the pixels into literals, colour cache hits and greedy
+// LZ77 copies. Besides hash chain candidates, the pixel directly above
+// and the one to the left are always tried, as their distance codes are
+// the shortest.
+func (e *vp8lEncoder) tokenize(width int) {
+	argb := e.argb
+	n := len(argb)
+	e.tokens = e.tokens[:0]
+
+	if len(e.head) == 0 {
+		e.head = make([]int32, 1<<vp8lHashBits)
+	}
+	for i := range e.head {
+		e.head[i] = -1
+	}
+	if cap(e.chain) < n {
+		e.chain = make([]int32, n)
+	}
+	chain := e.chain[:n]
+
+	// Shortest distance code for each short linear distance
+	codes := map[int]uint32{}
+	for code := len(vp8lDistanceMap); code >= 1; code-- {
+		codes[distanceFromCode(width, uint32(code))] = uint32(code)
+	}
+	distCode := func(d int) uint32 {
+		if code, ok := codes[d]; ok {
+			return code
+		}
+		return uint32(d + len(vp8lDistanceMap))
+	}
+
+	hash := func(i int) uint32 {
+		h := argb[i]*vp8lCacheMultiply ^ argb[i+1]*0x9e3779b1 ^ argb[i+2]*0x85ebca6b
+		return h >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+vp8lMinMatch <= n {
+			h := hash(i)
+			chain[i] = e.head[h]
+			e.head[h] = int32(i)
+		}
+	}
+
+	var cache [1 << vp8lCacheBits]uint32
+	cacheIndex := func(p uint32) uint32 {
+		return p * vp8lCacheMultiply >> (32 - vp8lCacheBits)
+	}
+
+	for i := 0; i < n; {
+		bestLen, bestCode := 0, uint32(0)
+		if i+vp8lMinMatch <= n {
+			limit := min(vp8lMaxLength, n-i)
+			try := func(c int) {
+				if c < 0 || i-c > vp8lMaxDistance {
+					return
+				}
+				l := 0
+				for l < limit && argb[c+l] == argb[i+l] {
+					l++
+				}
+				code := distCode(i - c)
+				if l > bestLen || (l == bestLen && code < bestCode) {
+					bestLen, bestCode = l, code
+				}
+			}
+			if i >= width {
+				try(i - width)
+			}
+			if i >= 1 {
+				try(i - 1)
+			}
+			c := e.head[hash(i)]
+			for tries := 0; c >= 0 && tries < vp8lMaxChain && bestLen < limit; tries++ {
+				try(int(c))
+				c = chain[c]
+			}
+		}
+
+		if bestLen >= vp8lMinMatch {
+			e.tokens = append(e.tokens, vp8lToken{kind: vp8lCopy, value: uint32(bestLen), dist: bestCode})
+			for j := i; j < i+bestLen; j++ {
+				insert(j)
+				cache[cacheIndex(argb[j])] = argb[j]
+			}
+			i += bestLen
+			continue
+		}
+
+		p := argb[i]
+		if k := cacheIndex(p); cache[k] == p {
+			e.tokens = append(e.tokens, vp8lToken{kind: vp8lCache, value: k})
+		} else {
+			e.tokens = append(e.tokens, vp8lToken{kind: vp8lLiteral, value: p})
+			cache[k] = p
+		}
+		insert(i)
+		i++
+	}
+}
+
+// distanceFromCode is the linear pixel distance a short distance code
+// stands for in an image width pixels wide
+func distanceFromCode(width int, code uint32) int {
+	v := vp8lDistanceMap[code-1]
+	d := int(v>>4)*width + 8 - int(v&0xf)
+	return max(d, 1)
+}
+
+// prefixEncode splits an LZ77 length or distance code into its prefix
+// symbol and the extra bits that follow it
+func prefixEncode(v uint32) (symbol, extraBits, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	hi := uint32(bits.Len32(d)) - 1
+	second := d >> (hi - 1) & 1
+	extraBits = hi - 1
+	return 2*hi + second, extraBits, d & (1<<extraBits - 1)
+}
+
+// bitWriter packs values least significant bit first
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint32
+}
+
+func (b *bitWriter) reset() {
+	b.buf = b.buf[:0]
+	b.acc, b.n = 0, 0
+}
+
+// write appends the low n bits of v
+func (b *bitWriter) write(v, n uint32) {
+	b.acc |= uint64(v&(1<<n-1)) << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.n -= 8
+	}
+}
+
+// flush pads the last byte with zeros
+func (b *bitWriter) flush() {
+	if b.n > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.n = 0, 0
+	}
+}
+
+// huffmanCode maps symbols to canonical codes. A symbol with length 0 is
+// written with no bits, which is how a code with one symbol works.
+type huffmanCode struct {
+	codes   []uint16
+	lengths []uint8
+}
+
+func (h huffmanCode) write(b *bitWriter, symbol uint32) {
+	n := uint32(h.lengths[symbol])
+	if n > 0 {
+		// Codes are read most significant bit first
+		b.write(uint32(bits.Reverse16(h.codes[symbol])>>(16-n)), n)
+	}
+}
+
+// writeHuffman writes the code for a histogram and returns it
+func (b *bitWriter) writeHuffman(counts []uint32) huffmanCode {
+	lengths := huffmanLengths(counts, vp8lMaxCodeBits)
+	var used []uint32
+	for s, l := range lengths {
+		if l > 0 {
+			used = append(used, uint32(s))
+		}
+	}
+
+	// Up to two symbols below 256 fit the compact simple code
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		b.write(1, 1)
+		if len(used) == 0 {
+			used = []uint32{0}
+		}
+		b.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			b.write(0, 1)
+			b.write(used[0], 1)
+		} else {
+			b.write(1, 1)
+			b.write(used[0], 8)
+		}
+		if len(used) == 2 {
+			b.write(used[1], 8)
+		}
+	} else {
+		b.write(0, 1)
+		b.writeCodeLengths(lengths)
+	}
+
+	if len(used) == 1 {
+		clear(lengths)
+	}
+	return huffmanCode{codes: canonicalCodes(lengths), lengths: lengths}
+}
+
+// writeCodeLengths writes code lengths run-length coded with a code
+// length code: 0-15 are lengths, 16 repeats the previous non-zero length
+// 3-6 times, 17 repeats zero 3-10 times and 18 repeats zero 11-138 times
+func (b *bitWriter) writeCodeLengths(lengths []uint8) {
+	type token struct{ symbol, extra uint32 }
+	var tokens []token
+	prev := uint8(8)
+	for i := 0; i < len(lengths); {
+		v := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == v {
+			run++
+		}
+		i += run
+
+		if v == 0 {
+			for run >= 11 {
+				k := min(run, 138)
+				tokens = append(tokens, token{18, uint32(k - 11)})
+				run -= k
+			}
+			if run >= 3 {
+				tokens = append(tokens, token{17, uint32(run - 3)})
+				run = 0
+			}
+		} else {
+			if v != prev {
+				tokens = append(tokens, token{uint32(v), 0})
+				prev = v
+				run--
+			}
+			for run >= 3 {
+				k := min(run, 6)
+				tokens = append(tokens, token{16, uint32(k - 3)})
+				run -= k
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{uint32(v), 0})
+		}
+	}
+
+	counts := make([]uint32, len(vp8lCodeLengthOrder))
+	for _, t := range tokens {
+		counts[t.symbol]++
+	}
+	clLengths := huffmanLengths(counts, vp8lMaxCLCodeBits)
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && clLengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	b.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		b.write(uint32(clLengths[s]), 3)
+	}
+	b.write(0, 1) // Lengths for the whole alphabet follow
+
+	used := 0
+	for _, l := range clLengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		clear(clLengths)
+	}
+	code := huffmanCode{codes: canonicalCodes(clLengths), lengths: clLengths}
+	extraBits := [3]uint32{2, 3, 7}
+	for _, t := range tokens {
+		code.write(b, t.symbol)
+		if t.symbol >= 16 {
+			b.write(t.extra, extraBits[t.symbol-16])
+		}
+	}
+}
+
+// huffmanLengths returns Huffman code lengths for a histogram, no longer
+// than maxBits. When the optimal code is too deep the counts are halved,
+// flattening the tree, until it fits. A lone symbol gets length 1.
+func huffmanLengths(counts []uint32, maxBits int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	type leaf struct {
+		symbol int
+		count  uint32
+	}
+	var leaves []leaf
+	for s, c := range counts {
+		if c > 0 {
+			leaves = append(leaves, leaf{s, c})
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[leaves[0].symbol] = 1
+		return lengths
+	}
+
+	type node struct {
+		count  uint64
+		parent int
+	}
+	for shift := 0; ; shift++ {
+		sort.Slice(leaves, func(i, j int) bool {
+			ci, cj := max(leaves[i].count>>shift, 1), max(leaves[j].count>>shift, 1)
+			if ci != cj {
+				return ci < cj
+			}
+			return leaves[i].symbol < leaves[j].symbol
+		})
+
+		// Two queues: sorted leaves, then internal nodes in creation order
+		nodes := make([]node, len(leaves), 2*len(leaves)-1)
+		for i, l := range leaves {
+			nodes[i] = node{count: uint64(max(l.count>>shift, 1)), parent: -1}
+		}
+		nextLeaf, nextInner := 0, len(leaves)
+		pick := func() int {
+			if nextLeaf < len(leaves) && (nextInner >= len(nodes) || nodes[nextLeaf].count <= nodes[nextInner].count) {
+				nextLeaf++
+				return nextLeaf - 1
+			}
+			nextInner++
+			return nextInner - 1
+		}
+		for len(nodes) < cap(nodes) {
+			a, b := pick(), pick()
+			nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, parent: -1})
+			nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+		}
+
+		// Parents come after their children, so depths fill in backwards
+		depth := make([]int, len(nodes))
+		deepest := 0
+		for i := len(nodes) - 2; i >= 0; i-- {
+			depth[i] = depth[nodes[i].parent] + 1
+			deepest = max(deepest, depth[i])
+		}
+		if deepest > maxBits {
+			continue
+		}
+		for i, l := range leaves {
+			lengths[l.symbol] = uint8(depth[i])
+		}
+		return lengths
+	}
+}
+
+// canonicalCodes assigns canonical Huffman codes to code lengths
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [vp8lMaxCodeBits + 1]uint16
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeBits + 1]uint16
+	code := uint16(0)
+	for l := 1; l <= vp8lMaxCodeBits; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = next[l]
+			next[l]++
+		}
+	}
+	return codes
+}
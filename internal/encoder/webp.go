@@ -0,0 +1,175 @@
+package encoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// WebP extended format flags and animation frame flags
+const (
+	webpFlagAnimation = 0x02
+	webpFlagAlpha     = 0x10
+
+	webpBlend   = 0x00 // Alpha blend the frame onto the canvas
+	webpNoBlend = 0x02 // Replace the frame's region instead of alpha blending
+)
+
+// WebPEncoder writes an animated lossless WebP. Frames keep full 24-bit
+// colour and alpha. In delta mode every frame after the first covers
+// only the rectangle that changed, widened to the even offsets WebP
+// requires, with unchanged pixels left transparent, and is alpha blended
+// over the canvas.
+//
+// The file size and alpha flag live in the header, so they are patched
+// in on Close; frames are still written as they arrive.
+type WebPEncoder struct {
+	opts     Options
+	w        io.WriteSeeker
+	base     int64 // Offset of the file in w
+	bw       *bufio.Writer
+	out      *countingWriter
+	vp8l     vp8lEncoder
+	timeline timeline[*image.RGBA]
+	last     *image.RGBA
+	delta    *image.RGBA // Buffer for delta frames
+	alpha    bool        // Whether any frame has transparent pixels
+	stats    Stats
+}
+
+// NewWebPEncoder creates an encoder writing an animated WebP to w
+func NewWebPEncoder(w io.WriteSeeker, opts Options) (*WebPEncoder, error) {
+	base, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	e := &WebPEncoder{
+		opts: opts,
+		w:    w,
+		base: base,
+		bw:   bufio.NewWriter(w),
+	}
+	e.out = &countingWriter{w: e.bw}
+	e.timeline = timeline[*image.RGBA]{
+		fps:     opts.FPS,
+		same:    sameImage,
+		similar: similarImages(opts.MergeBelow),
+		write:   e.write,
+		stats:   &e.stats,
+	}
+	return e, nil
+}
+
+// Sample does nothing: WebP frames are not quantized
+func (e *WebPEncoder) Sample(*image.RGBA) {}
+
+// WriteFrame adds the next frame of the animation
+func (e *WebPEncoder) WriteFrame(frame *image.RGBA) error {
+	return e.timeline.add(frame)
+}
+
+// Close writes the last frame, then fills in the file size and flags
+func (e *WebPEncoder) Close() (*Stats, error) {
+	if err := e.timeline.flush(true); err != nil {
+		return nil, err
+	}
+	if e.last == nil {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	if err := e.bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	end := e.base + e.out.n
+	size := binary.LittleEndian.AppendUint32(nil, uint32(e.out.n-8))
+	if err := patch(e.w, e.base+4, size, end); err != nil {
+		return nil, err
+	}
+	flags := byte(webpFlagAnimation)
+	if e.alpha {
+		flags |= webpFlagAlpha
+	}
+	if err := patch(e.w, e.base+20, []byte{flags}, end); err != nil {
+		return nil, err
+	}
+
+	e.stats.Bytes = e.out.n
+	stats := e.stats
+	return &stats, nil
+}
+
+// write writes a frame with its final delay
+func (e *WebPEncoder) write(frame *image.RGBA, delay int) error {
+	b := frame.Bounds()
+	first := e.last == nil
+	if first {
+		if err := e.header(b.Dx(), b.Dy()); err != nil {
+			return err
+		}
+	}
+
+	rect, src, blend := b, frame, byte(webpNoBlend)
+	if e.opts.Delta && !first {
+		rect = changedRect(e.last, frame)
+		// Frame offsets are stored halved
+		rect.Min.X -= (rect.Min.X - b.Min.X) % 2
+		rect.Min.Y -= (rect.Min.Y - b.Min.Y) % 2
+		var ok bool
+		if e.delta, ok = deltaRGBA(e.delta, e.last, frame, rect); ok {
+			src, blend = e.delta, webpBlend
+		}
+	}
+	data, alpha := e.vp8l.encode(src, rect)
+	e.alpha = e.alpha || alpha
+
+	anmf := make([]byte, 0, 16)
+	anmf = appendUint24(anmf, (rect.Min.X-b.Min.X)/2)
+	anmf = appendUint24(anmf, (rect.Min.Y-b.Min.Y)/2)
+	anmf = appendUint24(anmf, rect.Dx()-1)
+	anmf = appendUint24(anmf, rect.Dy()-1)
+	anmf = appendUint24(anmf, delay*10) // Milliseconds
+	anmf = append(anmf, blend)
+	vp8l := chunkHeader("VP8L", len(data))
+
+	size := len(anmf) + len(vp8l) + len(data) + len(data)%2
+	err := e.writeAll(chunkHeader("ANMF", size), anmf, vp8l, data, make([]byte, len(data)%2))
+	e.last = frame
+	return err
+}
+
+// header writes the RIFF header, with a placeholder size, and the
+// extended format and animation chunks
+func (e *WebPEncoder) header(width, height int) error {
+	vp8x := []byte{webpFlagAnimation, 0, 0, 0}
+	vp8x = appendUint24(vp8x, width-1)
+	vp8x = appendUint24(vp8x, height-1)
+
+	anim := []byte{0, 0, 0, 0} // Transparent background
+	anim = binary.LittleEndian.AppendUint16(anim, uint16(min(Plays(e.opts.LoopCount), 0xffff)))
+
+	return e.writeAll(
+		chunkHeader("RIFF", 0), []byte("WEBP"),
+		chunkHeader("VP8X", len(vp8x)), vp8x,
+		chunkHeader("ANIM", len(anim)), anim,
+	)
+}
+
+func (e *WebPEncoder) writeAll(parts ...[]byte) error {
+	for _, p := range parts {
+		if _, err := e.out.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkHeader returns a RIFF chunk's FourCC and little-endian size
+func chunkHeader(fourCC string, size int) []byte {
+	return binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(size))
+}
+
+func appendUint24(b []byte, v int) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16))
+}
@@ -3,6 +3,8 @@ package highlight
 import (
 	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/alecthomas/chroma/v2"
 	"github.com/alecthomas/chroma/v2/lexers"
@@ -19,6 +21,7 @@ type Token struct {
 type HighlightedCode struct {
 	Tokens []Token
 	Theme  string
+	Style  *chroma.Style
 }
 
 // Highlight applies syntax highlighting to code
@@ -30,11 +33,7 @@ func Highlight(code, language, themeName string) (*HighlightedCode, error) {
 	}
 	lexer = chroma.Coalesce(lexer)
 
-	// Get style/theme
-	style := styles.Get(themeName)
-	if style == nil {
-		style = styles.Fallback
-	}
+	style := Style(themeName)
 
 	// Tokenize the code
 	iterator, err := lexer.Tokenise(nil, code)
@@ -55,9 +54,20 @@ func Highlight(code, language, themeName string) (*HighlightedCode, error) {
 	return &HighlightedCode{
 		Tokens: tokens,
 		Theme:  themeName,
+		Style:  style,
 	}, nil
 }
 
+// Style returns the chroma style for a theme, or chroma's fallback style
+// if there is no such theme
+func Style(themeName string) *chroma.Style {
+	style := styles.Get(themeName)
+	if style == nil {
+		return styles.Fallback
+	}
+	return style
+}
+
 // ToPlainText returns the code as plain text (useful for debugging)
 func (h *HighlightedCode) ToPlainText() string {
 	var buf bytes.Buffer
@@ -73,7 +83,49 @@ func ListThemes() []string {
 	return names
 }
 
-// ValidateTheme checks if a theme exists
+// ValidateTheme checks if a theme exists. styles.Get never returns nil, so
+// the registry is consulted directly.
 func ValidateTheme(name string) bool {
-	return styles.Get(name) != nil
+	_, ok := styles.Registry[name]
+	return ok
+}
+
+// ValidateLanguage checks if chroma has a lexer for a language name, alias
+// or file extension
+func ValidateLanguage(name string) bool {
+	return lexers.Get(name) != nil
+}
+
+// Language describes one of chroma's lexers
+type Language struct {
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases"`
+	Filenames []string `json:"filenames"`
+}
+
+// ListLanguages returns every lexer chroma knows, sorted by name
+func ListLanguages() []Language {
+	var langs []Language
+	for _, lexer := range lexers.GlobalLexerRegistry.Lexers {
+		config := lexer.Config()
+		langs = append(langs, Language{
+			Name:      config.Name,
+			Aliases:   config.Aliases,
+			Filenames: config.Filenames,
+		})
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		return strings.ToLower(langs[i].Name) < strings.ToLower(langs[j].Name)
+	})
+	return langs
+}
+
+// LanguageName returns the name of the lexer chroma picks for a language
+// name, alias or extension, or "" if there is none
+func LanguageName(name string) string {
+	lexer := lexers.Get(name)
+	if lexer == nil {
+		return ""
+	}
+	return lexer.Config().Name
 }
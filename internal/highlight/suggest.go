@@ -0,0 +1,134 @@
+package highlight
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// maxSuggestions caps how many close matches an error lists
+const maxSuggestions = 3
+
+// UnknownError reports a theme or language chroma does not know, along with
+// the closest known names
+type UnknownError struct {
+	Kind        string // "theme" or "language"
+	Name        string
+	Suggestions []string
+}
+
+func (e *UnknownError) Error() string {
+	msg := fmt.Sprintf("unknown %s %q", e.Kind, e.Name)
+	switch len(e.Suggestions) {
+	case 0:
+		return msg
+	case 1:
+		return fmt.Sprintf("%s - did you mean %q?", msg, e.Suggestions[0])
+	default:
+		quoted := make([]string, len(e.Suggestions))
+		for i, s := range e.Suggestions {
+			quoted[i] = fmt.Sprintf("%q", s)
+		}
+		return fmt.Sprintf("%s - did you mean one of %s?", msg, strings.Join(quoted, ", "))
+	}
+}
+
+// CheckTheme returns an *UnknownError if no theme is registered as name
+func CheckTheme(name string) error {
+	if ValidateTheme(name) {
+		return nil
+	}
+	return &UnknownError{
+		Kind:        "theme",
+		Name:        name,
+		Suggestions: closestMatches(name, ListThemes()),
+	}
+}
+
+// CheckLanguage returns an *UnknownError if chroma has no lexer for name
+func CheckLanguage(name string) error {
+	if ValidateLanguage(name) {
+		return nil
+	}
+	return &UnknownError{
+		Kind:        "language",
+		Name:        name,
+		Suggestions: closestMatches(name, lexers.Names(true)),
+	}
+}
+
+// closestMatches returns up to maxSuggestions candidates within a small
+// edit distance of name, closest first. Matching ignores case, so "Pyhton"
+// still suggests "python".
+func closestMatches(name string, candidates []string) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+
+	target := strings.ToLower(name)
+	// Allow roughly one typo per three characters, and at least two so
+	// transpositions in short names are caught
+	limit := max(2, len([]rune(target))/3)
+
+	// Lexer names and aliases often differ only in case ("Python" and
+	// "python"); keep one spelling per name, preferring the lowercase alias
+	// that is easier to type
+	spelling := map[string]string{}
+	for _, c := range candidates {
+		key := strings.ToLower(c)
+		if prev, ok := spelling[key]; !ok || (c == key && prev != key) {
+			spelling[key] = c
+		}
+	}
+
+	var matches []match
+	for key, c := range spelling {
+		if d := editDistance(target, key); d <= limit {
+			matches = append(matches, match{c, d})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	var names []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance between a and b, so a swapped pair of letters costs one edit
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,62 @@
+package highlight
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"go", "go", 0},
+		{"", "abc", 3},
+		{"pyhton", "python", 1}, // A transposition is one edit
+		{"rsut", "rust", 1},
+		{"kitten", "sitting", 3},
+		{"日本", "日本語", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestClosestMatches(t *testing.T) {
+	candidates := []string{"Python", "python", "python3", "pythons", "pytho", "go", "Go", "rust", "ruby", "javascript", "typescript"}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Pyhton", []string{"python", "pytho", "python3"}}, // Closest first, then by name; pythons is cut
+		{"og", []string{"go"}},                             // Short names still allow two edits
+		{"rsut", []string{"rust"}},                         // ruby is three edits away
+		{"javscrpt", []string{"javascript"}},               // Two edits fit eight letters
+		{"jvscrpt", nil},                                   // Three do not fit seven
+		{"haskell", nil},
+	}
+	for _, tt := range tests {
+		if got := closestMatches(tt.name, candidates); !slices.Equal(got, tt.want) {
+			t.Errorf("closestMatches(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownError(t *testing.T) {
+	tests := []struct {
+		err  UnknownError
+		want string
+	}{
+		{UnknownError{"theme", "nope", nil}, `unknown theme "nope"`},
+		{UnknownError{"language", "pyhton", []string{"python"}}, `unknown language "pyhton" - did you mean "python"?`},
+		{UnknownError{"language", "rsut", []string{"rust", "ruby"}}, `unknown language "rsut" - did you mean one of "rust", "ruby"?`},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package highlight
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/styles"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadThemeFile reads a theme from disk and registers it with chroma under
+// its name, so later --theme flags and highlight calls can refer to it.
+// Supported formats are chroma's XML style format, a YAML or JSON map of
+// token types to chroma style strings, and VS Code JSON color themes.
+func LoadThemeFile(path string) (*chroma.Style, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme: %w", err)
+	}
+
+	fallbackName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var style *chroma.Style
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		style, err = chroma.NewXMLStyle(bytes.NewReader(data))
+	case ".yaml", ".yml":
+		style, err = parseTokenMapTheme(data, fallbackName)
+	case ".json", ".jsonc":
+		clean := stripJSONComments(data)
+		if isVSCodeTheme(clean) {
+			style, err = parseVSCodeTheme(clean, fallbackName)
+		} else {
+			style, err = parseTokenMapTheme(clean, fallbackName)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported theme file %s (want .xml, .yaml, .yml or .json)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse theme %s: %w", path, err)
+	}
+
+	return styles.Register(style), nil
+}
+
+// tokenMapTheme is the simple theme format: a name plus a map of chroma
+// token types to style strings such as "bold #ff79c6 bg:#282a36". Token
+// types can be written as "NameFunction", "name.function" or "name-function".
+type tokenMapTheme struct {
+	Name   string            `yaml:"name" json:"name"`
+	Tokens map[string]string `yaml:"tokens" json:"tokens"`
+}
+
+// parseTokenMapTheme parses the simple YAML/JSON theme format. JSON is
+// valid YAML, so both go through the YAML decoder.
+func parseTokenMapTheme(data []byte, fallbackName string) (*chroma.Style, error) {
+	var theme tokenMapTheme
+	if err := yaml.Unmarshal(data, &theme); err != nil {
+		return nil, err
+	}
+
+	// A file without a "tokens" section is a flat token map
+	if len(theme.Tokens) == 0 {
+		flat := map[string]string{}
+		if err := yaml.Unmarshal(data, &flat); err != nil {
+			return nil, fmt.Errorf("expected a map of token types to styles: %w", err)
+		}
+		delete(flat, "name")
+		theme.Tokens = flat
+	}
+	if len(theme.Tokens) == 0 {
+		return nil, fmt.Errorf("no token styles found")
+	}
+	if theme.Name == "" {
+		theme.Name = fallbackName
+	}
+
+	entries := chroma.StyleEntries{}
+	for key, value := range theme.Tokens {
+		ttype, err := parseTokenType(key)
+		if err != nil {
+			return nil, err
+		}
+		entries[ttype] = value
+	}
+	return chroma.NewStyle(theme.Name, entries)
+}
+
+// parseTokenType accepts chroma token type names in any case and with
+// dots, dashes or underscores between words
+func parseTokenType(name string) (chroma.TokenType, error) {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || r == ' ' {
+			return -1
+		}
+		return r
+	}, name)
+	ttype, err := chroma.TokenTypeString(normalized)
+	if err != nil {
+		return 0, fmt.Errorf("unknown token type %q", name)
+	}
+	return ttype, nil
+}
+
+// vscodeTheme is the subset of a VS Code color theme used here
+type vscodeTheme struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Colors      map[string]string `json:"colors"`
+	TokenColors []vscodeRule      `json:"tokenColors"`
+}
+
+type vscodeRule struct {
+	Scope    vscodeScopes `json:"scope"`
+	Settings struct {
+		Foreground string `json:"foreground"`
+		Background string `json:"background"`
+		FontStyle  string `json:"fontStyle"`
+	} `json:"settings"`
+}
+
+// vscodeScopes is a rule's scope, given as a comma separated string or a list
+type vscodeScopes []string
+
+func (s *vscodeScopes) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*s = list
+		return nil
+	}
+	var joined string
+	if err := json.Unmarshal(data, &joined); err != nil {
+		return err
+	}
+	for _, scope := range strings.Split(joined, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			*s = append(*s, scope)
+		}
+	}
+	return nil
+}
+
+// textMateScopes maps chroma token types to the TextMate scopes that
+// describe them, most specific first. Categories not listed inherit from
+// their parent token type as usual.
+var textMateScopes = map[chroma.TokenType][]string{
+	chroma.Comment:               {"comment"},
+	chroma.CommentPreproc:        {"meta.preprocessor", "keyword.control.directive"},
+	chroma.Keyword:               {"keyword.control", "keyword", "storage"},
+	chroma.KeywordConstant:       {"constant.language"},
+	chroma.KeywordDeclaration:    {"storage.type", "keyword.declaration"},
+	chroma.KeywordNamespace:      {"keyword.control.import", "keyword.other.import"},
+	chroma.KeywordType:           {"support.type", "storage.type", "entity.name.type"},
+	chroma.Name:                  {"variable", "source"},
+	chroma.NameAttribute:         {"entity.other.attribute-name"},
+	chroma.NameBuiltin:           {"support.function.builtin", "support.function"},
+	chroma.NameBuiltinPseudo:     {"variable.language"},
+	chroma.NameClass:             {"entity.name.type.class", "entity.name.class", "entity.name.type"},
+	chroma.NameConstant:          {"variable.other.constant", "constant.other"},
+	chroma.NameDecorator:         {"entity.name.function.decorator", "meta.decorator"},
+	chroma.NameException:         {"entity.name.type.exception", "entity.name.type"},
+	chroma.NameFunction:          {"entity.name.function", "support.function"},
+	chroma.NameNamespace:         {"entity.name.namespace", "entity.name.type.module"},
+	chroma.NameTag:               {"entity.name.tag"},
+	chroma.NameVariable:          {"variable.other", "variable"},
+	chroma.LiteralString:         {"string"},
+	chroma.LiteralStringDoc:      {"comment.block.documentation", "string.quoted.docstring"},
+	chroma.LiteralStringEscape:   {"constant.character.escape"},
+	chroma.LiteralStringRegex:    {"string.regexp"},
+	chroma.LiteralStringInterpol: {"meta.embedded", "punctuation.section.embedded"},
+	chroma.LiteralNumber:         {"constant.numeric"},
+	chroma.Operator:              {"keyword.operator"},
+	chroma.Punctuation:           {"punctuation"},
+	chroma.GenericDeleted:        {"markup.deleted"},
+	chroma.GenericInserted:       {"markup.inserted"},
+	chroma.GenericHeading:        {"markup.heading"},
+	chroma.GenericSubheading:     {"meta.diff.range", "markup.heading"},
+	chroma.GenericEmph:           {"markup.italic"},
+	chroma.GenericStrong:         {"markup.bold"},
+	chroma.Error:                 {"invalid"},
+}
+
+// isVSCodeTheme reports whether JSON looks like a VS Code color theme
+func isVSCodeTheme(data []byte) bool {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	_, hasTokenColors := probe["tokenColors"]
+	_, hasColors := probe["colors"]
+	return hasTokenColors || hasColors
+}
+
+// parseVSCodeTheme converts a VS Code color theme by resolving, for every
+// chroma token type, the most specific tokenColors rule matching one of
+// its TextMate scopes
+func parseVSCodeTheme(data []byte, fallbackName string) (*chroma.Style, error) {
+	var theme vscodeTheme
+	if err := json.Unmarshal(data, &theme); err != nil {
+		return nil, err
+	}
+	if theme.Name == "" {
+		theme.Name = fallbackName
+	}
+	name := strings.ToLower(strings.Join(strings.Fields(theme.Name), "-"))
+
+	background := vscodeColour(theme.Colors["editor.background"], "")
+	foreground := vscodeColour(theme.Colors["editor.foreground"], "")
+
+	// Rules without a scope hold the old-style global colours
+	for _, rule := range theme.TokenColors {
+		if len(rule.Scope) > 0 {
+			continue
+		}
+		if background == "" {
+			background = vscodeColour(rule.Settings.Background, "")
+		}
+		if foreground == "" {
+			foreground = vscodeColour(rule.Settings.Foreground, "")
+		}
+	}
+	if background == "" {
+		background = "#1e1e1e"
+		if theme.Type == "light" {
+			background = "#ffffff"
+		}
+	}
+
+	entries := chroma.StyleEntries{}
+	base := "bg:" + background
+	if foreground != "" {
+		base = foreground + " " + base
+	}
+	entries[chroma.Background] = base
+
+	if c := vscodeColour(theme.Colors["editorLineNumber.foreground"], background); c != "" {
+		entries[chroma.LineNumbers] = c
+	}
+	if c := vscodeColour(theme.Colors["editor.lineHighlightBackground"], background); c != "" {
+		entries[chroma.LineHighlight] = "bg:" + c
+	}
+
+	// Sort for a deterministic result regardless of map order
+	ttypes := make([]chroma.TokenType, 0, len(textMateScopes))
+	for ttype := range textMateScopes {
+		ttypes = append(ttypes, ttype)
+	}
+	sort.Slice(ttypes, func(i, j int) bool { return ttypes[i] < ttypes[j] })
+
+	for _, ttype := range ttypes {
+		rule := matchScopes(theme.TokenColors, textMateScopes[ttype])
+		if rule == nil {
+			continue
+		}
+		if entry := vscodeStyleEntry(rule, background); entry != "" {
+			entries[ttype] = entry
+		}
+	}
+
+	return chroma.NewStyle(name, entries)
+}
+
+// matchScopes finds the rule that best matches the first of the wanted
+// scopes any rule applies to. As in TextMate, a rule scope matches a wanted
+// scope it is a dot-separated prefix of, the longest prefix wins, and later
+// rules win ties.
+func matchScopes(rules []vscodeRule, wanted []string) *vscodeRule {
+	for _, want := range wanted {
+		var best *vscodeRule
+		bestLen := -1
+		for i := range rules {
+			for _, scope := range rules[i].Scope {
+				// Descendant selectors like "source.python string" are
+				// matched on their last scope only
+				fields := strings.Fields(scope)
+				if len(fields) == 0 {
+					continue
+				}
+				scope = fields[len(fields)-1]
+				if scope != want && !strings.HasPrefix(want, scope+".") {
+					continue
+				}
+				if len(scope) >= bestLen {
+					best, bestLen = &rules[i], len(scope)
+				}
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	return nil
+}
+
+// vscodeStyleEntry turns a rule's settings into a chroma style string
+func vscodeStyleEntry(rule *vscodeRule, background string) string {
+	var parts []string
+	for _, style := range strings.Fields(rule.Settings.FontStyle) {
+		switch style {
+		case "bold", "italic", "underline":
+			parts = append(parts, style)
+		}
+	}
+	if c := vscodeColour(rule.Settings.Foreground, background); c != "" {
+		parts = append(parts, c)
+	}
+	if c := vscodeColour(rule.Settings.Background, background); c != "" {
+		parts = append(parts, "bg:"+c)
+	}
+	return strings.Join(parts, " ")
+}
+
+// vscodeColour normalises a VS Code colour to #rrggbb. Colours with an
+// alpha channel are blended onto background when one is given.
+func vscodeColour(value, background string) string {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "#") {
+		return ""
+	}
+	hex := value[1:]
+	if len(hex) == 3 || len(hex) == 4 {
+		var long strings.Builder
+		for _, ch := range hex {
+			long.WriteRune(ch)
+			long.WriteRune(ch)
+		}
+		hex = long.String()
+	}
+
+	switch len(hex) {
+	case 6:
+		return "#" + strings.ToLower(hex)
+	case 8:
+		fg := chroma.ParseColour("#" + hex[:6])
+		bg := chroma.ParseColour(background)
+		if !fg.IsSet() {
+			return ""
+		}
+		if !bg.IsSet() {
+			return fg.String()
+		}
+		var alpha int
+		fmt.Sscanf(hex[6:], "%02x", &alpha)
+		blend := func(f, b uint8) uint8 {
+			return uint8((int(f)*alpha + int(b)*(255-alpha)) / 255)
+		}
+		return chroma.NewColour(blend(fg.Red(), bg.Red()), blend(fg.Green(), bg.Green()), blend(fg.Blue(), bg.Blue())).String()
+	}
+	return ""
+}
+
+var (
+	jsonComment       = regexp.MustCompile(`("(?:[^"\\]|\\.)*")|//[^\n]*|/\*[\s\S]*?\*/`)
+	jsonTrailingComma = regexp.MustCompile(`("(?:[^"\\]|\\.)*")|,(\s*[}\]])`)
+)
+
+// stripJSONComments removes the comments and trailing commas VS Code
+// allows in its JSON files, leaving string contents alone
+func stripJSONComments(data []byte) []byte {
+	data = jsonComment.ReplaceAllFunc(data, func(m []byte) []byte {
+		if m[0] == '"' {
+			return m
+		}
+		return nil
+	})
+	return jsonTrailingComma.ReplaceAll(data, []byte("$1$2"))
+}
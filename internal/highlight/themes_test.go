@@ -0,0 +1,191 @@
+package highlight
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{"a": 1} // note`, `{"a": 1} `},
+		{"{\n  // line\n  \"a\": 1\n}", "{\n  \n  \"a\": 1\n}"},
+		{`{/* block */"a": /* x */ 1}`, `{"a":  1}`},
+		{`{"a": [1, 2,], "b": 3,}`, `{"a": [1, 2], "b": 3}`},
+		{"{\"a\": 1,\n}", "{\"a\": 1\n}"},
+		// Comment and comma lookalikes inside strings are kept
+		{`{"url": "http://x.y/*z*/", "s": ",}"}`, `{"url": "http://x.y/*z*/", "s": ",}"}`},
+		{`{"q": "say \"//hi\""} // c`, `{"q": "say \"//hi\""} `},
+	}
+	for _, tt := range tests {
+		got := string(stripJSONComments([]byte(tt.in)))
+		if got != tt.want {
+			t.Errorf("stripJSONComments(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("stripJSONComments(%q) = %q, not valid JSON", tt.in, got)
+		}
+	}
+}
+
+func TestMatchScopes(t *testing.T) {
+	rules := func(scopes ...string) []vscodeRule {
+		rs := make([]vscodeRule, len(scopes))
+		for i, s := range scopes {
+			var scope vscodeScopes
+			if err := json.Unmarshal([]byte(`"`+s+`"`), &scope); err != nil {
+				t.Fatal(err)
+			}
+			rs[i].Scope = scope
+		}
+		return rs
+	}
+	tests := []struct {
+		name   string
+		rules  []vscodeRule
+		wanted []string
+		want   int // Index of the matching rule, -1 for none
+	}{
+		{"exact", rules("string", "comment"), []string{"comment"}, 1},
+		{"prefix", rules("entity.name"), []string{"entity.name.function"}, 0},
+		{"longest prefix wins", rules("entity.name.function", "entity"), []string{"entity.name.function"}, 0},
+		{"later rule wins a tie", rules("keyword", "keyword"), []string{"keyword.control"}, 1},
+		{"not a word prefix", rules("key"), []string{"keyword"}, -1},
+		{"more specific than wanted", rules("keyword.control"), []string{"keyword"}, -1},
+		{"first wanted scope that matches", rules("storage", "keyword"), []string{"keyword.control", "storage"}, 1},
+		{"falls back to later wanted scopes", rules("storage"), []string{"keyword.control", "storage.type"}, 0},
+		{"comma separated list", rules("comment, string.quoted"), []string{"string.quoted.double"}, 0},
+		{"descendant selector", rules("source.python string"), []string{"string"}, 0},
+		{"no rules", nil, []string{"string"}, -1},
+	}
+	for _, tt := range tests {
+		got := matchScopes(tt.rules, tt.wanted)
+		want := (*vscodeRule)(nil)
+		if tt.want >= 0 {
+			want = &tt.rules[tt.want]
+		}
+		if got != want {
+			t.Errorf("%s: matchScopes(%v) = %v, want rule %d", tt.name, tt.wanted, got, tt.want)
+		}
+	}
+}
+
+func TestParseTokenMapTheme(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantName string
+		want     map[chroma.TokenType]string // Token type to foreground colour
+	}{
+		{
+			"yaml with tokens",
+			"name: mine\ntokens:\n  Keyword: \"bold #ff79c6\"\n  name.function: \"#50fa7b\"\n  literal-string: \"#f1fa8c\"\n",
+			"mine",
+			map[chroma.TokenType]string{chroma.Keyword: "#ff79c6", chroma.NameFunction: "#50fa7b", chroma.LiteralString: "#f1fa8c"},
+		},
+		{
+			"flat json",
+			`{"name": "flat", "comment": "#6272a4", "literal.number": "#bd93f9"}`,
+			"flat",
+			map[chroma.TokenType]string{chroma.Comment: "#6272a4", chroma.LiteralNumber: "#bd93f9"},
+		},
+		{
+			"name from file",
+			"Keyword_Type: \"#8be9fd\"\n",
+			"fallback",
+			map[chroma.TokenType]string{chroma.KeywordType: "#8be9fd"},
+		},
+	}
+	for _, tt := range tests {
+		style, err := parseTokenMapTheme([]byte(tt.data), "fallback")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if style.Name != tt.wantName {
+			t.Errorf("%s: name = %q, want %q", tt.name, style.Name, tt.wantName)
+		}
+		for ttype, want := range tt.want {
+			if got := style.Get(ttype).Colour.String(); got != want {
+				t.Errorf("%s: %s colour = %s, want %s", tt.name, ttype, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadThemeFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	vscode := write("test-vscode.jsonc", `{
+		// A VS Code theme with comments and trailing commas
+		"name": "Test VS Code",
+		"type": "dark",
+		"colors": {"editor.background": "#101010", "editor.foreground": "#e0e0e0",},
+		"tokenColors": [
+			{"scope": "comment", "settings": {"foreground": "#808080", "fontStyle": "italic"}},
+			{"scope": ["keyword", "storage"], "settings": {"foreground": "#ff000080"}},
+			{"scope": "entity.name.function", "settings": {"foreground": "#0f0", "fontStyle": "bold"}},
+		],
+	}`)
+	style, err := LoadThemeFile(vscode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if style.Name != "test-vs-code" {
+		t.Errorf("name = %q, want test-vs-code", style.Name)
+	}
+	tests := []struct {
+		ttype      chroma.TokenType
+		colour     string
+		bold, ital bool
+	}{
+		{chroma.Background, "#e0e0e0", false, false},
+		{chroma.Comment, "#808080", false, true},
+		{chroma.Keyword, "#870707", false, false}, // Half alpha blended onto the background
+		{chroma.NameFunction, "#00ff00", true, false},
+	}
+	for _, tt := range tests {
+		e := style.Get(tt.ttype)
+		if e.Colour.String() != tt.colour || (e.Bold == chroma.Yes) != tt.bold || (e.Italic == chroma.Yes) != tt.ital {
+			t.Errorf("%s = %s, want colour %s bold %v italic %v", tt.ttype, e, tt.colour, tt.bold, tt.ital)
+		}
+	}
+	if bg := style.Get(chroma.Background).Background.String(); bg != "#101010" {
+		t.Errorf("background = %s, want #101010", bg)
+	}
+
+	// Malformed themes are errors, never panics
+	malformed := []struct{ name, data string }{
+		{"test-empty.yaml", ""},
+		{"test-null.json", "null"},
+		{"test-bad.yaml", "tokens: [unclosed\n"},
+		{"test-list.yaml", "- Keyword: bold\n"},
+		{"test-nested.yaml", "tokens:\n  Keyword:\n    colour: red\n"},
+		{"test-unknown.yaml", "NotAToken: \"#ffffff\"\n"},
+		{"test-truncated.json", `{"name": "x", "tokens": {"Keyword": "#fff"`},
+		{"test-scope.json", `{"colors": {}, "tokenColors": [{"scope": 42}]}`},
+		{"test-rules.json", `{"colors": {}, "tokenColors": {"scope": "comment"}}`},
+		{"test-bad.xml", "<style name=\"x\"><entry type=\"Keyword\""},
+		{"test-theme.toml", "Keyword = \"#ffffff\"\n"},
+	}
+	for _, m := range malformed {
+		if _, err := LoadThemeFile(write(m.name, m.data)); err == nil {
+			t.Errorf("LoadThemeFile(%s) succeeded, want an error", m.name)
+		}
+	}
+	if _, err := LoadThemeFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadThemeFile succeeded on a missing file")
+	}
+}
@@ -0,0 +1,292 @@
+package parser
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// GuessThreshold is the confidence below which a detection is a guess
+// and worth warning the user about
+const GuessThreshold = 0.5
+
+// Detection is the result of language detection
+type Detection struct {
+	Language   string  // chroma lexer name or alias, "text" if unknown
+	Source     string  // what the decision was based on
+	Confidence float64 // 0 (no idea) to 1 (certain)
+}
+
+// IsGuess reports whether the detection is too weak to trust silently
+func (d Detection) IsGuess() bool {
+	return d.Confidence < GuessThreshold
+}
+
+// extensionLanguages maps common extensions to chroma language names
+var extensionLanguages = map[string]string{
+	".go":     "go",
+	".py":     "python",
+	".js":     "javascript",
+	".jsx":    "jsx",
+	".ts":     "typescript",
+	".tsx":    "tsx",
+	".rs":     "rust",
+	".rb":     "ruby",
+	".java":   "java",
+	".c":      "c",
+	".cpp":    "cpp",
+	".cc":     "cpp",
+	".h":      "c",
+	".hpp":    "cpp",
+	".cs":     "csharp",
+	".php":    "php",
+	".swift":  "swift",
+	".kt":     "kotlin",
+	".scala":  "scala",
+	".sh":     "bash",
+	".bash":   "bash",
+	".zsh":    "bash",
+	".fish":   "fish",
+	".ps1":    "powershell",
+	".r":      "r",
+	".sql":    "sql",
+	".html":   "html",
+	".css":    "css",
+	".scss":   "scss",
+	".sass":   "sass",
+	".json":   "json",
+	".yaml":   "yaml",
+	".yml":    "yaml",
+	".toml":   "toml",
+	".xml":    "xml",
+	".md":     "markdown",
+	".vim":    "vim",
+	".lua":    "lua",
+	".pl":     "perl",
+	".ex":     "elixir",
+	".exs":    "elixir",
+	".erl":    "erlang",
+	".hs":     "haskell",
+	".clj":    "clojure",
+	".lisp":   "commonlisp",
+	".dart":   "dart",
+	".vue":    "vue",
+	".svelte": "svelte",
+}
+
+// filenameLanguages maps well-known file names that carry no useful extension
+var filenameLanguages = map[string]string{
+	"dockerfile":     "docker",
+	"containerfile":  "docker",
+	"makefile":       "make",
+	"gnumakefile":    "make",
+	"cmakelists.txt": "cmake",
+	"jenkinsfile":    "groovy",
+	"vagrantfile":    "ruby",
+	"gemfile":        "ruby",
+	"rakefile":       "ruby",
+	"podfile":        "ruby",
+	"caddyfile":      "caddyfile",
+	"meson.build":    "meson",
+	"nginx.conf":     "nginx",
+	".envrc":         "bash",
+	".bashrc":        "bash",
+	".bash_profile":  "bash",
+	".zshrc":         "bash",
+	".zprofile":      "bash",
+	".profile":       "bash",
+	".gitconfig":     "ini",
+	".editorconfig":  "ini",
+}
+
+// interpreterLanguages maps shebang interpreters to chroma language names
+// where the interpreter is not itself a lexer alias
+var interpreterLanguages = map[string]string{
+	"sh":      "bash",
+	"dash":    "bash",
+	"ash":     "bash",
+	"node":    "javascript",
+	"nodejs":  "javascript",
+	"deno":    "typescript",
+	"ts-node": "typescript",
+	"pwsh":    "powershell",
+	"rscript": "r",
+	"tclsh":   "tcl",
+	"wish":    "tcl",
+	"runghc":  "haskell",
+	"escript": "erlang",
+}
+
+var (
+	versionSuffix = regexp.MustCompile(`[0-9.]+$`)
+
+	// vim: set ft=python:  /  vi: syntax=ruby  /  ex: filetype=go
+	vimModeline = regexp.MustCompile(`(?:^|\s)(?:vim?|ex):.*?\b(?:ft|filetype|syntax|syn)=([A-Za-z0-9_+-]+)`)
+	// -*- mode: python -*-  /  -*- python -*-
+	emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*?mode:\s*)?([A-Za-z0-9_+-]+)\s*(?:;.*?)?-\*-`)
+)
+
+// modelineScanLines is how many lines at each end of a file are searched
+// for an editor modeline, mirroring vim's default 'modelines' setting
+const modelineScanLines = 5
+
+// DetectLanguage works out the language of code from its path and content.
+// Signals are tried from most to least explicit: editor modelines, exact
+// file names, extensions, chroma's filename patterns, shebang lines and
+// finally chroma's content analysers. path may be empty for stdin.
+func DetectLanguage(path, code string) Detection {
+	if lang := detectModeline(code); lang != "" {
+		return Detection{Language: lang, Source: "modeline", Confidence: 1.0}
+	}
+
+	if path != "" {
+		base := strings.ToLower(filepath.Base(path))
+		if lang, ok := filenameLanguages[base]; ok {
+			return Detection{Language: lang, Source: "filename", Confidence: 1.0}
+		}
+
+		if lang, ok := extensionLanguages[strings.ToLower(filepath.Ext(path))]; ok {
+			return Detection{Language: lang, Source: "extension", Confidence: 0.9}
+		}
+
+		if lexer := lexers.Match(filepath.Base(path)); lexer != nil {
+			return Detection{Language: lexerID(lexer), Source: "filename pattern", Confidence: 0.8}
+		}
+	}
+
+	if lang := detectShebang(code); lang != "" {
+		return Detection{Language: lang, Source: "shebang", Confidence: 0.9}
+	}
+
+	if lexer, score := analyse(code); lexer != nil {
+		// Analyser scores are heuristics; never trust them more than a
+		// filename match
+		confidence := float64(score)
+		if confidence > 0.7 {
+			confidence = 0.7
+		}
+		return Detection{Language: lexerID(lexer), Source: "content", Confidence: confidence}
+	}
+
+	return Detection{Language: "text", Source: "fallback", Confidence: 0}
+}
+
+// Extensions returns the file extensions DetectLanguage maps directly,
+// keyed by extension
+func Extensions() map[string]string {
+	out := make(map[string]string, len(extensionLanguages))
+	for ext, lang := range extensionLanguages {
+		out[ext] = lang
+	}
+	return out
+}
+
+// detectModeline looks for a vim or emacs modeline near the start or end
+// of the code
+func detectModeline(code string) string {
+	lines := strings.Split(code, "\n")
+	candidates := lines
+	if len(lines) > modelineScanLines*2 {
+		candidates = append(append([]string{}, lines[:modelineScanLines]...), lines[len(lines)-modelineScanLines:]...)
+	}
+
+	for _, line := range candidates {
+		for _, re := range []*regexp.Regexp{vimModeline, emacsModeline} {
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if lang := resolveLanguage(m[1]); lang != "" {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// detectShebang maps a "#!" interpreter line to a language
+func detectShebang(code string) string {
+	if !strings.HasPrefix(code, "#!") {
+		return ""
+	}
+
+	line, _, _ := strings.Cut(code[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	// "#!/usr/bin/env -S python3 -u" names the interpreter after env's flags
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+				continue
+			}
+			interpreter = filepath.Base(field)
+			break
+		}
+	}
+	interpreter = strings.ToLower(interpreter)
+	if interpreter == "" {
+		return ""
+	}
+
+	for _, name := range []string{interpreter, versionSuffix.ReplaceAllString(interpreter, "")} {
+		if lang, ok := interpreterLanguages[name]; ok {
+			return lang
+		}
+		if lang := resolveLanguage(name); lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+// analyse runs every chroma content analyser and returns the best match
+// together with its score
+func analyse(code string) (chroma.Lexer, float32) {
+	var picked chroma.Lexer
+	highest := float32(0)
+	for _, lexer := range lexers.GlobalLexerRegistry.Lexers {
+		analyser, ok := lexer.(chroma.Analyser)
+		if !ok {
+			continue
+		}
+		if weight := analyser.AnalyseText(code); weight > highest {
+			picked = lexer
+			highest = weight
+		}
+	}
+	return picked, highest
+}
+
+// resolveLanguage returns the canonical id for a chroma name or alias,
+// or "" if chroma does not know it
+func resolveLanguage(name string) string {
+	lexer := lexers.Get(name)
+	if lexer == nil || lexer == lexers.Fallback {
+		return ""
+	}
+	return lexerID(lexer)
+}
+
+// lexerID picks the identifier used for a lexer throughout the tool: its
+// lowercased name when that is also an alias, otherwise its first alias
+func lexerID(lexer chroma.Lexer) string {
+	config := lexer.Config()
+	name := strings.ToLower(config.Name)
+	for _, alias := range config.Aliases {
+		if alias == name {
+			return name
+		}
+	}
+	if len(config.Aliases) > 0 {
+		return config.Aliases[0]
+	}
+	return name
+}
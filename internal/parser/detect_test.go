@@ -0,0 +1,84 @@
+package parser
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		code   string
+		lang   string
+		source string
+		guess  bool
+	}{
+		{"vim modeline", "notes.txt", "# vim: set ft=python:\nprint(1)\n", "python", "modeline", false},
+		{"vim syntax modeline", "", "x = 1\n# vi: syntax=ruby\n", "ruby", "modeline", false},
+		{"emacs modeline", "", "# -*- mode: python -*-\n", "python", "modeline", false},
+		{"emacs short modeline", "", "// -*- go -*-\n", "go", "modeline", false},
+		{"modeline beats extension", "main.js", "// vim: ft=typescript\n", "typescript", "modeline", false},
+		{"modeline past the scanned lines", "main.go", "a\nb\nc\nd\ne\n# vim: ft=ruby\nf\ng\nh\ni\nj\nk\n", "go", "extension", false},
+		{"filename", "path/to/Dockerfile", "FROM alpine\n", "docker", "filename", false},
+		{"extension", "main.GO", "package main\n", "go", "extension", false},
+		{"filename pattern", "build.gradle", "", "groovy", "filename pattern", false},
+		{"shebang", "", "#!/bin/bash\necho hi\n", "bash", "shebang", false},
+		{"shebang with env", "", "#!/usr/bin/env node\n", "javascript", "shebang", false},
+		{"shebang with env -S", "", "#!/usr/bin/env -S python3 -u\n", "python", "shebang", false},
+		{"shebang with env assignment", "", "#!/usr/bin/env FOO=1 ruby\n", "ruby", "shebang", false},
+		{"shebang version suffix", "", "#!/usr/bin/python3.12\n", "python", "shebang", false},
+		{"shebang mapped interpreter", "", "#!/bin/sh\n", "bash", "shebang", false},
+		{"unknown shebang", "", "#!/usr/bin/frobnicate\n", "text", "fallback", true},
+		{"fallback", "", "just some words\n", "text", "fallback", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectLanguage(tt.path, tt.code)
+			if got.Language != tt.lang || got.Source != tt.source {
+				t.Errorf("DetectLanguage(%q) = %s from %s, want %s from %s", tt.path, got.Language, got.Source, tt.lang, tt.source)
+			}
+			if got.IsGuess() != tt.guess {
+				t.Errorf("IsGuess() = %v at confidence %.2f, want %v", got.IsGuess(), got.Confidence, tt.guess)
+			}
+		})
+	}
+}
+
+func TestDetectLanguageContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       string
+		lang       string
+		confidence float64
+	}{
+		// The DNS analyser is certain, but content never beats a filename match
+		{"clamped", "$ORIGIN example.com.\n@ IN SOA ns1 admin 1 2 3 4 5\n", "zone", 0.7},
+		{"unclamped", "package main\n\nfunc main() { fmt.Println() }\n", "go", 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectLanguage("", tt.code)
+			if got.Language != tt.lang || got.Source != "content" {
+				t.Fatalf("DetectLanguage = %s from %s, want %s from content", got.Language, got.Source, tt.lang)
+			}
+			if got.Confidence != tt.confidence {
+				t.Errorf("Confidence = %.2f, want %.2f", got.Confidence, tt.confidence)
+			}
+		})
+	}
+}
+
+func TestIsGuess(t *testing.T) {
+	tests := []struct {
+		confidence float64
+		guess      bool
+	}{
+		{0, true},
+		{GuessThreshold - 0.01, true},
+		{GuessThreshold, false},
+		{1, false},
+	}
+	for _, tt := range tests {
+		if got := (Detection{Confidence: tt.confidence}).IsGuess(); got != tt.guess {
+			t.Errorf("Detection{Confidence: %.2f}.IsGuess() = %v, want %v", tt.confidence, got, tt.guess)
+		}
+	}
+}
@@ -2,8 +2,9 @@ package parser
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -17,68 +18,27 @@ func ReadFile(path string) (string, error) {
 	return string(data), nil
 }
 
-// DetectLanguage detects the programming language from file extension
-func DetectLanguage(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
-	
-	// Map common extensions to chroma language names
-	langMap := map[string]string{
-		".go":     "go",
-		".py":     "python",
-		".js":     "javascript",
-		".jsx":    "jsx",
-		".ts":     "typescript",
-		".tsx":    "tsx",
-		".rs":     "rust",
-		".rb":     "ruby",
-		".java":   "java",
-		".c":      "c",
-		".cpp":    "cpp",
-		".cc":     "cpp",
-		".h":      "c",
-		".hpp":    "cpp",
-		".cs":     "csharp",
-		".php":    "php",
-		".swift":  "swift",
-		".kt":     "kotlin",
-		".scala":  "scala",
-		".sh":     "bash",
-		".bash":   "bash",
-		".zsh":    "bash",
-		".fish":   "fish",
-		".ps1":    "powershell",
-		".r":      "r",
-		".sql":    "sql",
-		".html":   "html",
-		".css":    "css",
-		".scss":   "scss",
-		".sass":   "sass",
-		".json":   "json",
-		".yaml":   "yaml",
-		".yml":    "yaml",
-		".toml":   "toml",
-		".xml":    "xml",
-		".md":     "markdown",
-		".vim":    "vim",
-		".lua":    "lua",
-		".pl":     "perl",
-		".ex":     "elixir",
-		".exs":    "elixir",
-		".erl":    "erlang",
-		".hs":     "haskell",
-		".clj":    "clojure",
-		".lisp":   "commonlisp",
-		".dart":   "dart",
-		".vue":    "vue",
-		".svelte": "svelte",
+// ReadStdin reads the code piped into standard input. It refuses to block
+// on an interactive terminal, where nothing has been piped in.
+func ReadStdin() (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return "", fmt.Errorf("no input: pass a file path or pipe code into stdin")
 	}
 
-	if lang, ok := langMap[ext]; ok {
-		return lang
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
 	}
+	return string(data), nil
+}
 
-	// Default to text if unknown
-	return "text"
+// IsStdin reports whether a path argument refers to standard input
+func IsStdin(path string) bool {
+	return path == "" || path == "-"
 }
 
 // ParseHighlightLines parses highlight line specification (e.g., "5,7-9" -> [5,7,8,9])
@@ -92,7 +52,7 @@ func ParseHighlightLines(spec string) ([]int, error) {
 
 	for _, part := range parts {
 		part = strings.TrimSpace(part)
-		
+
 		// Check for range (e.g., "7-9")
 		if strings.Contains(part, "-") {
 			rangeParts := strings.Split(part, "-")
@@ -130,3 +90,39 @@ func ParseHighlightLines(spec string) ([]int, error) {
 
 	return lines, nil
 }
+
+// sizeUnits are the suffixes ParseSize accepts, longest first so "MB" is
+// not read as "B"
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a file size such as "5MB", "500KB" or "1.5M" into
+// bytes. Units are binary (1KB = 1024 bytes) and a bare number is bytes.
+func ParseSize(spec string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(spec))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 5MB, 500KB or a number of bytes)", spec)
+	}
+	bytes := n * float64(unit)
+	switch {
+	case bytes < 1:
+		return 0, fmt.Errorf("size %q is under 1 byte", spec)
+	case bytes >= math.MaxInt64: // 2^63 itself does not fit in an int64
+		return 0, fmt.Errorf("size %q is too large", spec)
+	}
+	return int64(bytes), nil
+}
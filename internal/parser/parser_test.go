@@ -0,0 +1,33 @@
+package parser
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"500", 500, false},
+		{" 5MB ", 5 << 20, false},
+		{"500kb", 500 << 10, false},
+		{"1.5M", 3 << 19, false},
+		{"2 G", 2 << 30, false},
+		{"1.5", 1, false},
+		{"0.5", 0, true},
+		{"0.0001KB", 0, true},
+		{"0", 0, true},
+		{"-1MB", 0, true},
+		{"8589934592GB", 0, true},
+		{"1e300", 0, true},
+		{"inf", 0, true},
+		{"MB", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package render
+
+import (
+	"math"
+
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// scrollEaseChars is how many typed characters a scroll takes to settle
+const scrollEaseChars = 16
+
+// scrollMarginLines keeps this many lines visible below the cursor
+const scrollMarginLines = 1
+
+// CursorScroll returns how far the code is scrolled when the cursor is at
+// cursorPos. The camera keeps the cursor line inside the viewport and eases
+// between lines instead of jumping, so the result only depends on cursorPos
+// and frames can still be rendered independently of each other.
+func (r *Renderer) CursorScroll(tokens []highlight.Token, cursorPos int) float64 {
+	if r.config.Overflow != OverflowScroll {
+		return 0
+	}
+
+	layout := r.measure(tokens)
+
+	// Weight the follow target of the last few cursor positions with a
+	// smoothstep-shaped kernel, turning each line jump into an eased glide
+	sum, weights := 0.0, 0.0
+	for i := 0; i < scrollEaseChars; i++ {
+		pos := cursorPos - i
+		if pos < 0 {
+			pos = 0
+		}
+		t := (float64(i) + 0.5) / scrollEaseChars
+		w := 6 * t * (1 - t)
+		sum += w * r.followScroll(layout.at(pos).Row, layout.rows)
+		weights += w
+	}
+	return sum / weights
+}
+
+// LineScroll returns the scroll that centres a 1-based line in the viewport,
+// clamped so the code never scrolls past its first or last line
+func (r *Renderer) LineScroll(tokens []highlight.Token, line int) float64 {
+	if r.config.Overflow != OverflowScroll {
+		return 0
+	}
+	layout := r.measure(tokens)
+	line = max(1, min(line, layout.lines))
+	visible := r.visibleLines()
+	top := float64(layout.lineRows[line-1]) - float64(visible-1)/2
+	return r.clampScroll(top*r.lineHeight(), layout.rows)
+}
+
+// EaseInOut maps linear progress in [0, 1] to a cubic ease-in-out curve
+func EaseInOut(t float64) float64 {
+	t = math.Max(0, math.Min(1, t))
+	if t < 0.5 {
+		return 4 * t * t * t
+	}
+	return 1 - math.Pow(-2*t+2, 3)/2
+}
+
+// followScroll is the scroll needed to keep a 0-based cursor row on screen
+func (r *Renderer) followScroll(row, totalRows int) float64 {
+	lastVisible := r.visibleLines() - 1 - scrollMarginLines
+	if lastVisible < 0 {
+		lastVisible = 0
+	}
+	top := float64(row - lastVisible)
+	return r.clampScroll(top*r.lineHeight(), totalRows)
+}
+
+// clampScroll keeps scroll between the first row and the point where the
+// last row sits at the bottom of the viewport
+func (r *Renderer) clampScroll(scroll float64, totalRows int) float64 {
+	maxScroll := float64(totalRows-r.visibleLines()) * r.lineHeight()
+	return math.Max(0, math.Min(scroll, maxScroll))
+}
+
+// visibleLines is how many whole rows fit between the chrome and padding
+func (r *Renderer) visibleLines() int {
+	chromeHeight := 0.0
+	if r.config.WindowStyle == "macos" || r.config.WindowStyle == "windows" {
+		chromeHeight = 40.0 * r.config.ScaleFactor
+	}
+	area := float64(r.config.Height) - float64(r.config.Padding)*2 - chromeHeight
+	n := int(area / r.lineHeight())
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// lineHeight is the distance between consecutive baselines
+func (r *Renderer) lineHeight() float64 {
+	return r.config.FontSize * r.config.LineHeight
+}
@@ -0,0 +1,360 @@
+package render
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// FontFamily holds the four faces code is drawn with. Variants a family
+// lacks are filled in from the closest face it does have.
+type FontFamily struct {
+	Name       string
+	Regular    *opentype.Font
+	Bold       *opentype.Font
+	Italic     *opentype.Font
+	BoldItalic *opentype.Font
+}
+
+// fontExtensions are the font files LoadFontFamily understands
+var fontExtensions = map[string]bool{
+	".ttf": true,
+	".otf": true,
+	".ttc": true,
+	".otc": true,
+}
+
+// FontDirs returns the standard Linux font directories searched for a
+// family name, most specific first
+func FontDirs() []string {
+	var dirs []string
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs,
+			filepath.Join(home, ".local", "share", "fonts"),
+			filepath.Join(home, ".fonts"),
+		)
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		dirs = append(dirs, filepath.Join(dataHome, "fonts"))
+	}
+	return append(dirs, "/usr/local/share/fonts", "/usr/share/fonts")
+}
+
+// LoadFontFamily resolves the --font and --font-dir settings. spec may be
+// a font file, whose other variants are looked up in dir (or next to the
+// file when dir is empty), or a family name looked up in dir (or the
+// standard font directories when dir is empty). With neither set, the
+// embedded Go Mono family is used.
+func LoadFontFamily(spec, dir string) (*FontFamily, error) {
+	if spec == "" && dir == "" {
+		return goMonoFamily()
+	}
+
+	if spec != "" && fontExtensions[strings.ToLower(filepath.Ext(spec))] {
+		return loadFontFile(spec, dir)
+	}
+
+	dirs := FontDirs()
+	if dir != "" {
+		dirs = []string{dir}
+	}
+
+	candidates, err := scanFontDirs(dirs)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no font files found in %s", strings.Join(dirs, ", "))
+	}
+
+	// A directory on its own is expected to hold a single family
+	if spec == "" {
+		spec = candidates[0].family
+	}
+
+	family := &FontFamily{Name: spec}
+	for _, c := range candidates {
+		if normalizeFamily(c.family) == normalizeFamily(spec) {
+			family.set(c)
+		}
+	}
+	if family.Regular == nil && family.Bold == nil && family.Italic == nil && family.BoldItalic == nil {
+		return nil, fmt.Errorf("font family %q not found in %s", spec, strings.Join(dirs, ", "))
+	}
+	family.fill()
+	return family, nil
+}
+
+// goMonoFamily returns the embedded Go Mono faces
+func goMonoFamily() (*FontFamily, error) {
+	family := &FontFamily{Name: "Go Mono"}
+	for _, face := range []struct {
+		dst  **opentype.Font
+		data []byte
+	}{
+		{&family.Regular, gomono.TTF},
+		{&family.Bold, gomonobold.TTF},
+		{&family.Italic, gomonoitalic.TTF},
+		{&family.BoldItalic, gomonobolditalic.TTF},
+	} {
+		f, err := opentype.Parse(face.data)
+		if err != nil {
+			return nil, err
+		}
+		*face.dst = f
+	}
+	return family, nil
+}
+
+// fontCandidate is one face found on disk
+type fontCandidate struct {
+	font   *opentype.Font
+	family string
+	bold   bool
+	italic bool
+	plain  bool // A true regular weight rather than light, medium and so on
+}
+
+// loadFontFile loads a single font file and looks for its other variants
+// in dir, or next to the file when dir is empty
+func loadFontFile(path, dir string) (*FontFamily, error) {
+	requested, err := readFontFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("no usable faces in %s", path)
+	}
+
+	// The requested file always provides the face it is
+	family := &FontFamily{Name: requested[0].family}
+	for _, c := range requested {
+		family.set(c)
+	}
+
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	siblings, err := scanFontDirs([]string{dir})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range siblings {
+		if normalizeFamily(c.family) == normalizeFamily(family.Name) {
+			family.setIfEmpty(c)
+		}
+	}
+	family.fill()
+	return family, nil
+}
+
+// scanFontDirs reads every font file below dirs, skipping unreadable ones
+func scanFontDirs(dirs []string) ([]fontCandidate, error) {
+	var candidates []fontCandidate
+	for i, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			// Only an explicit --font-dir has to exist
+			if len(dirs) == 1 && i == 0 {
+				return nil, fmt.Errorf("font directory: %w", err)
+			}
+			continue
+		}
+
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !fontExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			faces, err := readFontFile(path)
+			if err != nil {
+				return nil
+			}
+			candidates = append(candidates, faces...)
+			return nil
+		})
+	}
+	return candidates, nil
+}
+
+// readFontFile parses a font or font collection file
+func readFontFile(path string) ([]fontCandidate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %w", err)
+	}
+
+	collection, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font %s: %w", path, err)
+	}
+
+	var buf sfnt.Buffer
+	var candidates []fontCandidate
+	for i := 0; i < collection.NumFonts(); i++ {
+		f, err := collection.Font(i)
+		if err != nil {
+			continue
+		}
+
+		family, err := f.Name(&buf, sfnt.NameIDTypographicFamily)
+		if err != nil || family == "" {
+			family, _ = f.Name(&buf, sfnt.NameIDFamily)
+		}
+		style, err := f.Name(&buf, sfnt.NameIDTypographicSubfamily)
+		if err != nil || style == "" {
+			style, _ = f.Name(&buf, sfnt.NameIDSubfamily)
+		}
+		if family == "" {
+			family = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+
+		c := classifyStyle(style)
+		c.font = f
+		c.family = family
+		candidates = append(candidates, c)
+	}
+	return candidates, nil
+}
+
+// classifyStyle works out weight and slant from a subfamily name such as
+// "Bold Italic", "Oblique" or "SemiBold"
+func classifyStyle(style string) fontCandidate {
+	s := strings.ToLower(strings.ReplaceAll(style, " ", ""))
+	c := fontCandidate{
+		italic: strings.Contains(s, "italic") || strings.Contains(s, "oblique"),
+	}
+
+	heavy := strings.Contains(s, "bold") || strings.Contains(s, "black") || strings.Contains(s, "heavy")
+	partial := strings.Contains(s, "semi") || strings.Contains(s, "demi") ||
+		strings.Contains(s, "extra") || strings.Contains(s, "ultra")
+	c.bold = heavy && !partial
+
+	switch strings.NewReplacer("italic", "", "oblique", "").Replace(s) {
+	case "", "regular", "normal", "book", "roman", "bold":
+		c.plain = true
+	}
+	return c
+}
+
+// set stores a candidate in its slot, preferring exact regular and bold
+// weights over in-between ones
+func (f *FontFamily) set(c fontCandidate) {
+	slot := f.slot(c)
+	if *slot == nil || c.plain {
+		*slot = c.font
+	}
+}
+
+// setIfEmpty stores a candidate only if its slot has nothing yet
+func (f *FontFamily) setIfEmpty(c fontCandidate) {
+	if slot := f.slot(c); *slot == nil && c.plain {
+		*slot = c.font
+	}
+}
+
+func (f *FontFamily) slot(c fontCandidate) **opentype.Font {
+	switch {
+	case c.bold && c.italic:
+		return &f.BoldItalic
+	case c.bold:
+		return &f.Bold
+	case c.italic:
+		return &f.Italic
+	default:
+		return &f.Regular
+	}
+}
+
+// fill substitutes the nearest available face for missing variants
+func (f *FontFamily) fill() {
+	first := func(fonts ...*opentype.Font) *opentype.Font {
+		for _, font := range fonts {
+			if font != nil {
+				return font
+			}
+		}
+		return nil
+	}
+	f.Regular = first(f.Regular, f.Bold, f.Italic, f.BoldItalic)
+	f.Bold = first(f.Bold, f.Regular)
+	f.Italic = first(f.Italic, f.Regular)
+	f.BoldItalic = first(f.BoldItalic, f.Bold, f.Italic)
+}
+
+// HasGlyph reports whether the regular face can draw ch
+func (f *FontFamily) HasGlyph(ch rune) bool {
+	var buf sfnt.Buffer
+	idx, err := f.Regular.GlyphIndex(&buf, ch)
+	return err == nil && idx != 0
+}
+
+// normalizeFamily makes family names comparable regardless of case and spacing
+func normalizeFamily(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(name)), ""))
+}
+
+// newFace creates a drawable face at size pixels
+func newFace(f *opentype.Font, size float64) font.Face {
+	// opentype.NewFace never fails; the error is only there for API symmetry
+	face, _ := opentype.NewFace(f, &opentype.FaceOptions{
+		Size: size,
+		DPI:  72,
+	})
+	return face
+}
+
+// Sizes of the decorative faces relative to the code font size
+const (
+	lineNumberScale = 0.8 // Line numbers in the gutter
+	kineticScale    = 8.0 // Language name in the background
+)
+
+// faceSet holds the four variants of a family at one size, plus the faces
+// used for line numbers and the background language name. Faces are built
+// once per renderer and reused for every frame.
+type faceSet struct {
+	regular    font.Face
+	bold       font.Face
+	italic     font.Face
+	boldItalic font.Face
+	lineNumber font.Face
+	kinetic    font.Face
+}
+
+// newFaceSet creates faces for every variant of the family at size pixels
+func (f *FontFamily) newFaceSet(size float64) *faceSet {
+	return &faceSet{
+		regular:    newFace(f.Regular, size),
+		bold:       newFace(f.Bold, size),
+		italic:     newFace(f.Italic, size),
+		boldItalic: newFace(f.BoldItalic, size),
+		lineNumber: newFace(f.Regular, size*lineNumberScale),
+		kinetic:    newFace(f.Bold, size*kineticScale),
+	}
+}
+
+// forStyle picks the face matching a token's bold and italic attributes
+func (fs *faceSet) forStyle(style chroma.StyleEntry) font.Face {
+	bold := style.Bold == chroma.Yes
+	italic := style.Italic == chroma.Yes
+	switch {
+	case bold && italic:
+		return fs.boldItalic
+	case bold:
+		return fs.bold
+	case italic:
+		return fs.italic
+	default:
+		return fs.regular
+	}
+}
@@ -0,0 +1,196 @@
+package render
+
+import (
+	"math"
+
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+	"golang.org/x/image/font"
+)
+
+// Wrap modes for lines wider than the canvas
+const (
+	WrapNone = "none" // Let long lines run off the right edge
+	WrapSoft = "soft" // Continue long lines on the next row with a hanging indent
+	WrapClip = "clip" // Cut long lines off at the right padding
+)
+
+// tabStop is the number of space widths between tab stops
+const tabStop = 4
+
+// wrapIndicators are tried in order as the marker drawn at the start of a
+// continuation row; not every font has the nicer hooked arrow
+var wrapIndicators = []rune{'↪', '→'}
+
+// glyph is the laid-out position of one rune of the code. X is relative to
+// the left edge of the text area and Row counts visual rows from the top.
+type glyph struct {
+	X     float64
+	Width float64
+	Row   int
+	Line  int
+}
+
+// wrapMark is a continuation row that gets a wrap indicator
+type wrapMark struct {
+	Row   int
+	X     float64
+	First int // Index of the first rune on the row
+}
+
+// textLayout holds the position of every rune when all code is revealed
+type textLayout struct {
+	glyphs   []glyph
+	end      glyph      // Where the cursor sits after the last rune
+	rows     int        // Visual rows, not counting an empty row after a trailing newline
+	lines    int        // Logical lines, not counting an empty line after a trailing newline
+	lineRows []int      // First visual row of each logical line
+	lineEnds []int      // Index of the newline ending each logical line, or len(glyphs)
+	wraps    []wrapMark // Continuation rows in soft wrap mode
+}
+
+// at returns the position of rune index i, or the end position past the last rune
+func (l *textLayout) at(i int) glyph {
+	if i >= 0 && i < len(l.glyphs) {
+		return l.glyphs[i]
+	}
+	return l.end
+}
+
+// textAreaWidth is the width available to code right of the gutter
+func (r *Renderer) textAreaWidth() float64 {
+	return float64(r.config.Width-2*r.config.Padding) - r.gutterWidth()
+}
+
+// gutterWidth is the space reserved for line numbers
+func (r *Renderer) gutterWidth() float64 {
+	if r.config.LineNumbers {
+		return 50.0 * r.config.ScaleFactor
+	}
+	return 0
+}
+
+// FitColumns sizes the canvas width to hold cols cells of the regular
+// face per row, as a terminal that many columns wide would
+func (r *Renderer) FitColumns(cols int) {
+	cell := advance(r.faces.regular, 'M')
+	r.config.Width = int(math.Ceil(float64(cols)*cell + 2*float64(r.config.Padding) + r.gutterWidth()))
+	r.layout = nil
+}
+
+// layoutText positions every rune of the tokens, breaking rows at word
+// boundaries in soft wrap mode
+func (r *Renderer) layoutText(tokens []highlight.Token, faces *faceSet) *textLayout {
+	// Bold and italic faces can be wider than regular ones, so each rune
+	// is measured with the face it will be drawn in
+	var runes []rune
+	var runeFaces []font.Face
+	for _, token := range tokens {
+		face := faces.forStyle(token.Style)
+		for _, ch := range token.Text {
+			runes = append(runes, ch)
+			runeFaces = append(runeFaces, face)
+		}
+	}
+
+	spaceWidth := advance(faces.regular, ' ')
+	maxX := r.textAreaWidth()
+	wrap := r.config.Wrap == WrapSoft
+
+	// Continuation rows start after the line's own indent plus room for
+	// the marker, but never so deep that nothing fits
+	markerWidth := 0.0
+	if wrap {
+		markerWidth = advance(faces.regular, r.wrapIndicator()) + spaceWidth
+	}
+
+	l := &textLayout{
+		glyphs:   make([]glyph, len(runes)),
+		lineRows: []int{0},
+	}
+
+	x, row, line := 0.0, 0, 0
+	rowStart := 0 // Index of the first rune on the current row
+	breakAt := -1 // Index just after the last space on the current row
+	indent := 0.0 // Leading whitespace width of the current line
+	inIndent := true
+
+	for i, ch := range runes {
+		if ch == '\n' {
+			l.glyphs[i] = glyph{X: x, Row: row, Line: line}
+			l.lineEnds = append(l.lineEnds, i)
+			row++
+			line++
+			l.lineRows = append(l.lineRows, row)
+			x, rowStart, breakAt, indent, inIndent = 0, i+1, -1, 0, true
+			continue
+		}
+
+		w := advance(runeFaces[i], ch)
+		if ch == '\t' {
+			stop := spaceWidth * tabStop
+			w = (math.Floor(x/stop)+1)*stop - x
+		}
+
+		if inIndent {
+			if ch == ' ' || ch == '\t' {
+				indent += w
+			} else {
+				inIndent = false
+			}
+		}
+
+		if wrap && !inIndent && x+w > maxX && i > rowStart {
+			// Move the partial word onto a new row when there is a space
+			// to break at, otherwise break mid-word
+			start := i
+			if breakAt > rowStart {
+				start = breakAt
+			}
+
+			hang := math.Min(indent, maxX/2)
+			row++
+			x = hang + markerWidth
+			l.wraps = append(l.wraps, wrapMark{Row: row, X: hang, First: start})
+			for j := start; j < i; j++ {
+				l.glyphs[j].X = x
+				l.glyphs[j].Row = row
+				x += l.glyphs[j].Width
+			}
+			rowStart, breakAt = start, -1
+		}
+
+		l.glyphs[i] = glyph{X: x, Width: w, Row: row, Line: line}
+		x += w
+		if ch == ' ' || ch == '\t' {
+			breakAt = i + 1
+		}
+	}
+	l.lineEnds = append(l.lineEnds, len(runes))
+
+	l.end = glyph{X: x, Row: row, Line: line}
+	l.rows = row + 1
+	l.lines = line + 1
+	if len(runes) > 0 && runes[len(runes)-1] == '\n' {
+		l.rows--
+		l.lines--
+		l.lineRows = l.lineRows[:l.lines]
+		l.lineEnds = l.lineEnds[:l.lines]
+	}
+	return l
+}
+
+// wrapIndicator returns the first wrap marker the font can draw
+func (r *Renderer) wrapIndicator() rune {
+	for _, ch := range wrapIndicators {
+		if r.fonts.HasGlyph(ch) {
+			return ch
+		}
+	}
+	return wrapIndicators[len(wrapIndicators)-1]
+}
+
+// advance returns the horizontal advance of a rune in pixels
+func advance(face font.Face, ch rune) float64 {
+	a, _ := face.GlyphAdvance(ch)
+	return float64(a) / 64
+}
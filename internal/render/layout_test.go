@@ -0,0 +1,185 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// newLayoutRenderer returns a renderer whose text area is cols cells of
+// the embedded monospace font wide
+func newLayoutRenderer(t *testing.T, wrap string, lineNumbers bool, cols int) *Renderer {
+	t.Helper()
+	r, err := NewRenderer(Options{Width: 400, FontSize: 16, Wrap: wrap, LineNumbers: lineNumbers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.FitColumns(cols)
+	return r
+}
+
+func TestLayoutText(t *testing.T) {
+	// Positions are given in cells, plus markers for the width of a wrap
+	// marker and "half" for the capped hanging indent
+	type pos struct {
+		row     int
+		cells   float64
+		markers float64
+		half    bool
+	}
+	tests := []struct {
+		name        string
+		code        string
+		wrap        string
+		lineNumbers bool
+		want        map[int]pos // Rune index to position
+		rows, lines int
+		lineRows    []int
+		wraps       []int // First rune of each continuation row
+	}{
+		{
+			name:     "short lines",
+			code:     "ab\ncd",
+			wrap:     WrapSoft,
+			want:     map[int]pos{1: {0, 1, 0, false}, 2: {0, 2, 0, false}, 3: {1, 0, 0, false}, 4: {1, 1, 0, false}},
+			rows:     2,
+			lines:    2,
+			lineRows: []int{0, 1},
+		},
+		{
+			name:     "trailing newline",
+			code:     "ab\n",
+			wrap:     WrapSoft,
+			want:     map[int]pos{1: {0, 1, 0, false}},
+			rows:     1,
+			lines:    1,
+			lineRows: []int{0},
+		},
+		{
+			name:     "tab stop",
+			code:     "a\tx",
+			wrap:     WrapSoft,
+			want:     map[int]pos{2: {0, tabStop, 0, false}},
+			rows:     1,
+			lines:    1,
+			lineRows: []int{0},
+		},
+		{
+			name:     "word moves to the next row",
+			code:     "aaaa bbbbbbb",
+			wrap:     WrapSoft,
+			want:     map[int]pos{4: {0, 4, 0, false}, 5: {1, 0, 1, false}, 11: {1, 6, 1, false}},
+			rows:     2,
+			lines:    1,
+			lineRows: []int{0},
+			wraps:    []int{5},
+		},
+		{
+			name:     "long word breaks mid-word",
+			code:     "aaaaaaaaaaaa",
+			wrap:     WrapSoft,
+			want:     map[int]pos{9: {0, 9, 0, false}, 10: {1, 0, 1, false}, 11: {1, 1, 1, false}},
+			rows:     2,
+			lines:    1,
+			lineRows: []int{0},
+			wraps:    []int{10},
+		},
+		{
+			name:     "hanging indent",
+			code:     "    aaaa bbbb",
+			wrap:     WrapSoft,
+			want:     map[int]pos{4: {0, 4, 0, false}, 9: {1, 4, 1, false}},
+			rows:     2,
+			lines:    1,
+			lineRows: []int{0},
+			wraps:    []int{9},
+		},
+		{
+			name:     "hanging indent capped at half the width",
+			code:     "        aaa",
+			wrap:     WrapSoft,
+			want:     map[int]pos{7: {0, 7, 0, false}, 8: {1, 0, 1, true}, 10: {1, 2, 1, true}},
+			rows:     2,
+			lines:    1,
+			lineRows: []int{0},
+			wraps:    []int{8},
+		},
+		{
+			name:     "wrapped line pushes down the next",
+			code:     "aaaa bbbbbbb\ncc",
+			wrap:     WrapSoft,
+			want:     map[int]pos{13: {2, 0, 0, false}},
+			rows:     3,
+			lines:    2,
+			lineRows: []int{0, 2},
+			wraps:    []int{5},
+		},
+		{
+			name:        "line numbers leave the text area its columns",
+			code:        "aaaa bbbbbbb\ncc",
+			wrap:        WrapSoft,
+			lineNumbers: true,
+			want:        map[int]pos{5: {1, 0, 1, false}, 13: {2, 0, 0, false}},
+			rows:        3,
+			lines:       2,
+			lineRows:    []int{0, 2},
+			wraps:       []int{5},
+		},
+		{
+			name:     "no wrap runs off the edge",
+			code:     "aaaa bbbbbbb\ncc",
+			wrap:     WrapNone,
+			want:     map[int]pos{11: {0, 11, 0, false}, 13: {1, 0, 0, false}},
+			rows:     2,
+			lines:    2,
+			lineRows: []int{0, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newLayoutRenderer(t, tt.wrap, tt.lineNumbers, 10)
+			cell := advance(r.faces.regular, 'M')
+			marker := advance(r.faces.regular, r.wrapIndicator()) + advance(r.faces.regular, ' ')
+
+			l := r.measure([]highlight.Token{{Text: tt.code}})
+			for i, p := range tt.want {
+				x := p.cells*cell + p.markers*marker
+				if p.half {
+					x += r.textAreaWidth() / 2
+				}
+				if g := l.at(i); g.Row != p.row || g.X != x {
+					t.Errorf("rune %d (%q) at row %d x %.2f, want row %d x %.2f", i, tt.code[i], g.Row, g.X, p.row, x)
+				}
+			}
+			if l.rows != tt.rows || l.lines != tt.lines {
+				t.Errorf("%d rows and %d lines, want %d and %d", l.rows, l.lines, tt.rows, tt.lines)
+			}
+			// Line numbers are drawn on the first row of each line only
+			if !equalInts(l.lineRows, tt.lineRows) {
+				t.Errorf("lineRows %v, want %v", l.lineRows, tt.lineRows)
+			}
+			var wraps []int
+			for _, w := range l.wraps {
+				wraps = append(wraps, w.First)
+				if first := l.at(w.First); first.Row != w.Row || first.X != w.X+marker {
+					t.Errorf("marker at row %d x %.2f, but its row starts at row %d x %.2f", w.Row, w.X, first.Row, first.X)
+				}
+			}
+			if !equalInts(wraps, tt.wraps) {
+				t.Errorf("continuation rows start at runes %v, want %v", wraps, tt.wraps)
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
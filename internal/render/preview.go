@@ -0,0 +1,64 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// RenderPreview sizes the canvas to the tokens and renders them fully
+// typed, without a cursor, as a still image
+func (r *Renderer) RenderPreview(tokens []highlight.Token) (*image.RGBA, error) {
+	if err := r.FitContent(tokens); err != nil {
+		return nil, err
+	}
+	return r.RenderFrame(tokens, totalChars(tokens), false, 1.0)
+}
+
+// ContactSheet arranges tiles in a grid of columns with a caption under
+// each one, on a neutral grey that suits dark and light themes alike
+func ContactSheet(tiles []*image.RGBA, captions []string, columns int) (*image.RGBA, error) {
+	if columns < 1 {
+		columns = 1
+	}
+	columns = min(columns, max(1, len(tiles)))
+
+	cellWidth, cellHeight := 0, 0
+	for _, tile := range tiles {
+		cellWidth = max(cellWidth, tile.Bounds().Dx())
+		cellHeight = max(cellHeight, tile.Bounds().Dy())
+	}
+
+	fonts, err := goMonoFamily()
+	if err != nil {
+		return nil, err
+	}
+	const captionSize = 16.0
+	captionHeight := int(captionSize * 2.5)
+	margin := 24
+
+	rows := int(math.Ceil(float64(len(tiles)) / float64(columns)))
+	dc := gg.NewContext(
+		margin+columns*(cellWidth+margin),
+		margin+rows*(cellHeight+captionHeight+margin),
+	)
+	dc.SetColor(color.RGBA{128, 130, 138, 255})
+	dc.Clear()
+	dc.SetFontFace(newFace(fonts.Bold, captionSize))
+
+	for i, tile := range tiles {
+		x := margin + (i%columns)*(cellWidth+margin)
+		y := margin + (i/columns)*(cellHeight+captionHeight+margin)
+		dc.DrawImage(tile, x+(cellWidth-tile.Bounds().Dx())/2, y)
+
+		if i < len(captions) {
+			dc.SetColor(color.RGBA{255, 255, 255, 255})
+			dc.DrawStringAnchored(captions[i], float64(x)+float64(cellWidth)/2, float64(y+cellHeight)+float64(captionHeight)/2, 0.5, 0.35)
+		}
+	}
+
+	return dc.Image().(*image.RGBA), nil
+}
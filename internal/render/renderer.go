@@ -1,16 +1,16 @@
 package render
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/maphash"
 	"image"
 	"image/color"
 	"math"
-	"strings"
 
+	"github.com/alecthomas/chroma/v2"
 	"github.com/fogleman/gg"
 	"github.com/forbiddenlink/gif-my-code/internal/highlight"
-	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font/gofont/gomonobold"
 )
 
 // Config holds rendering configuration
@@ -21,6 +21,8 @@ type Config struct {
 	Padding        int
 	LineHeight     float64
 	BgColor        color.Color
+	TextColor      color.Color
+	LineNumColor   color.Color
 	CursorColor    color.Color
 	HighlightColor color.Color
 	HighlightLines map[int]bool
@@ -33,63 +35,175 @@ type Config struct {
 	ScaleFactor    float64
 	Language       string
 	LaserReveal    bool
+	MinHeight      int
+	MaxHeight      int
+	Overflow       string // "error", "clip", "scroll"
+	Wrap           string // "none", "soft", "clip"
+	Background     string // "theme", "gradient"
+	Dark           bool   // Whether the background is dark
+	// ThemeBackground is the theme's own background; token backgrounds equal
+	// to it are not painted
+	ThemeBackground chroma.Colour
 }
 
-// Renderer handles image rendering
+// Options holds the user-facing settings a Renderer is built from
+type Options struct {
+	Width          int
+	FontSize       float64
+	HighlightLines []int
+	WindowStyle    string
+	Theme          string
+	HiDPI          bool
+	LineNumbers    bool
+	Language       string
+	LaserReveal    bool
+	MinHeight      int           // Lower bound for the auto-sized height, 0 for none
+	MaxHeight      int           // Upper bound for the auto-sized height, 0 for none
+	Overflow       string        // What to do when content exceeds MaxHeight
+	Wrap           string        // What to do with lines wider than the canvas, WrapSoft if ""
+	Font           string        // Font file or family name, "" for Go Mono
+	FontDir        string        // Directory to find the font family in
+	Style          *chroma.Style // Theme the tokens were highlighted with
+	Background     string        // Window body style, "theme" or "gradient"
+}
+
+// Overflow modes for content taller than the maximum height
+const (
+	OverflowError  = "error"  // Refuse to render
+	OverflowClip   = "clip"   // Render at the maximum height and cut off the rest
+	OverflowScroll = "scroll" // Render at the maximum height and scroll to follow the cursor
+)
+
+// Renderer handles image rendering. It draws with font faces that keep
+// internal state, so it must not be used from several goroutines at once;
+// give each goroutine its own Clone instead.
 type Renderer struct {
 	config Config
-	font   *truetype.Font
+	fonts  *FontFamily
+	faces  *faceSet
+
+	// The layout of the last tokens measured, shared read-only by clones
+	layout       *textLayout
+	layoutTokens []highlight.Token // The slice laid out
+	layoutKey    uint64            // tokensKey of the tokens laid out
+
+	// The background language name, shared read-only by clones
+	watermark *watermark
 }
 
 // NewRenderer creates a new renderer with enhanced visual config
-func NewRenderer(width int, fontSize float64, highlightLines []int, windowStyle string, theme string, hiDPI bool, lineNumbers bool, language string, laserReveal bool) (*Renderer, error) {
-	// Parse the embedded font
-	font, err := truetype.Parse(gomonobold.TTF)
+func NewRenderer(opts Options) (*Renderer, error) {
+	// Load the requested typeface, or the embedded Go Mono family
+	fonts, err := LoadFontFamily(opts.Font, opts.FontDir)
 	if err != nil {
 		return nil, err
 	}
 
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = OverflowError
+	case OverflowError, OverflowClip, OverflowScroll:
+	default:
+		return nil, fmt.Errorf("unknown overflow mode %q (want %s, %s or %s)", opts.Overflow, OverflowError, OverflowClip, OverflowScroll)
+	}
+	switch opts.Wrap {
+	case "":
+		opts.Wrap = WrapSoft
+	case WrapNone, WrapSoft, WrapClip:
+	default:
+		return nil, fmt.Errorf("unknown wrap mode %q (want %s, %s or %s)", opts.Wrap, WrapSoft, WrapNone, WrapClip)
+	}
+	switch opts.Background {
+	case "":
+		opts.Background = BackgroundTheme
+	case BackgroundTheme, BackgroundGradient:
+	default:
+		return nil, fmt.Errorf("unknown background %q (want %s or %s)", opts.Background, BackgroundTheme, BackgroundGradient)
+	}
+	if opts.Overflow == OverflowScroll && opts.MaxHeight <= 0 {
+		return nil, fmt.Errorf("%s overflow needs a max height for the viewport", OverflowScroll)
+	}
+	if opts.MaxHeight > 0 && opts.MinHeight > opts.MaxHeight {
+		return nil, fmt.Errorf("min height (%d) must be <= max height (%d)", opts.MinHeight, opts.MaxHeight)
+	}
+
 	// Convert highlight lines to map for O(1) lookup
 	highlightMap := make(map[int]bool)
-	for _, line := range highlightLines {
+	for _, line := range opts.HighlightLines {
 		highlightMap[line] = true
 	}
 
 	scaleFactor := 1.0
-	if hiDPI {
+	if opts.HiDPI {
 		scaleFactor = 2.0
 	}
 
-	// Enhanced config with professional styling - Cinematic Deep Space theme
+	// Window colours follow the theme so light themes stay readable
+	colors := colorsFromStyle(opts.Style)
+
+	// Enhanced config with professional styling
 	config := Config{
-		Width:          int(float64(width) * scaleFactor),
-		Height:         int(600 * scaleFactor), // Will be calculated based on content
-		FontSize:       fontSize * scaleFactor,
-		Padding:        int(36.0 * scaleFactor),        // Tighter, more professional padding
-		LineHeight:     1.5,                            // Better readability
-		BgColor:        color.RGBA{15, 17, 26, 255},    // Deep Space Window Base (#0F111A)
-		CursorColor:    color.RGBA{255, 255, 255, 220}, // Slightly more opaque
-		HighlightColor: color.RGBA{255, 255, 255, 15},  // Subtle white wash for highlight background
+		Width:          int(float64(opts.Width) * scaleFactor),
+		Height:         int(600 * scaleFactor), // Replaced by FitContent once tokens are known
+		FontSize:       opts.FontSize * scaleFactor,
+		Padding:        int(36.0 * scaleFactor), // Tighter, more professional padding
+		LineHeight:     1.5,                     // Better readability
+		BgColor:        colors.Background,
+		TextColor:      colors.Text,
+		LineNumColor:   colors.LineNumber,
+		CursorColor:    colors.Cursor,
+		HighlightColor: colors.Highlight,
 		HighlightLines: highlightMap,
-		WindowStyle:    windowStyle,
-		Theme:          theme,
+		WindowStyle:    opts.WindowStyle,
+		Theme:          opts.Theme,
 		CornerRadius:   16.0 * scaleFactor, // Smoother, larger rounded corners
 		ShadowEnabled:  true,               // Drop shadow
-		HiDPI:          hiDPI,
-		LineNumbers:    lineNumbers,
+		HiDPI:          opts.HiDPI,
+		LineNumbers:    opts.LineNumbers,
 		ScaleFactor:    scaleFactor,
-		Language:       language,
-		LaserReveal:    laserReveal,
+		Language:       opts.Language,
+		LaserReveal:    opts.LaserReveal,
+		MinHeight:      int(float64(opts.MinHeight) * scaleFactor),
+		MaxHeight:      int(float64(opts.MaxHeight) * scaleFactor),
+		Overflow:       opts.Overflow,
+		Wrap:           opts.Wrap,
+		Background:     opts.Background,
+		Dark:           colors.Dark,
+	}
+	if opts.Style != nil {
+		config.ThemeBackground = opts.Style.Get(chroma.Background).Background
 	}
 
 	return &Renderer{
 		config: config,
-		font:   font,
+		fonts:  fonts,
+		faces:  fonts.newFaceSet(config.FontSize),
 	}, nil
 }
 
-// RenderFrame renders a single frame with the given tokens and cursor position
+// Clone returns a renderer with the same settings and canvas size but its
+// own font faces, for rendering frames concurrently
+func (r *Renderer) Clone() *Renderer {
+	return &Renderer{
+		config:       r.config,
+		fonts:        r.fonts,
+		faces:        r.fonts.newFaceSet(r.config.FontSize),
+		layout:       r.layout,
+		layoutTokens: r.layoutTokens,
+		layoutKey:    r.layoutKey,
+		watermark:    r.watermark,
+	}
+}
+
+// RenderFrame renders a single frame with the given tokens and cursor position.
+// In scroll overflow mode the viewport follows the cursor.
 func (r *Renderer) RenderFrame(tokens []highlight.Token, cursorPos int, showCursor bool, progress float64) (*image.RGBA, error) {
+	return r.RenderFrameScrolled(tokens, cursorPos, showCursor, progress, r.CursorScroll(tokens, cursorPos))
+}
+
+// RenderFrameScrolled renders a single frame with the code scrolled up by
+// scroll pixels (in canvas units, so already scaled for HiDPI)
+func (r *Renderer) RenderFrameScrolled(tokens []highlight.Token, cursorPos int, showCursor bool, progress float64, scroll float64) (*image.RGBA, error) {
 	// Create context with extra space for shadow
 	shadowOffset := 20.0 * r.config.ScaleFactor
 	dc := gg.NewContext(r.config.Width+int(shadowOffset*2), r.config.Height+int(shadowOffset*2))
@@ -108,63 +222,92 @@ func (r *Renderer) RenderFrame(tokens []highlight.Token, cursorPos int, showCurs
 		r.drawWindowsChrome(dc, shadowOffset)
 	}
 
-	// Load font face
-	face := truetype.NewFace(r.font, &truetype.Options{
-		Size: r.config.FontSize,
-	})
-	dc.SetFontFace(face)
+	faces := r.faces
+	dc.SetFontFace(faces.regular)
+	layout := r.measure(tokens)
+
+	gutterWidth := r.gutterWidth()
 
-	gutterWidth := 0.0
-	if r.config.LineNumbers {
-		gutterWidth = 50.0 * r.config.ScaleFactor
+	// Track position (adjusted for shadow offset)
+	chromeHeight := 0.0
+	if r.config.WindowStyle == "macos" || r.config.WindowStyle == "windows" {
+		chromeHeight = 40.0 * r.config.ScaleFactor // Title bar height
+	}
+
+	// Code taller than the viewport, scrolled or clipped, must not spill
+	// over the title bar or past the window. A gg clip mask would cost a
+	// canvas-sized image for every glyph, so instead the pixels around the
+	// viewport are saved here and put back once the code is drawn.
+	viewTop := shadowOffset + chromeHeight
+	viewBottom := shadowOffset + float64(r.config.Height)
+	var saved *image.RGBA
+	if scroll != 0 || r.CalculateHeight(tokens) > r.config.Height {
+		saved = cloneRGBA(dc.Image().(*image.RGBA))
 	}
 
 	// First pass: draw line highlights and line numbers
 	if len(r.config.HighlightLines) > 0 || r.config.LineNumbers {
-		r.drawLineHighlights(dc, tokens, cursorPos, shadowOffset, gutterWidth)
+		r.drawLineHighlights(dc, layout, cursorPos, shadowOffset, gutterWidth, scroll)
 	}
 
-	// Track position (adjusted for shadow offset)
-	chromeHeight := 0.0
-	if r.config.WindowStyle == "macos" || r.config.WindowStyle == "windows" {
-		chromeHeight = 40.0 * r.config.ScaleFactor // Title bar height
+	originX := float64(r.config.Padding) + shadowOffset + gutterWidth
+	originY := float64(r.config.Padding) + r.config.FontSize + shadowOffset + chromeHeight - scroll
+	rowHeight := r.config.FontSize * r.config.LineHeight
+	rightEdge := originX + r.textAreaWidth()
+
+	// Wrap indicators for continuation rows the cursor has reached
+	if len(layout.wraps) > 0 {
+		dc.SetColor(r.config.LineNumColor)
+		indicator := string(r.wrapIndicator())
+		for _, mark := range layout.wraps {
+			if mark.First > cursorPos {
+				break
+			}
+			dc.DrawString(indicator, originX+mark.X, originY+float64(mark.Row)*rowHeight)
+		}
 	}
 
-	x := float64(r.config.Padding) + shadowOffset + gutterWidth
-	y := float64(r.config.Padding) + r.config.FontSize + shadowOffset + chromeHeight
+	// Token backgrounds go underneath all of the text
+	r.drawTokenBackgrounds(dc, tokens, layout, cursorPos, originX, originY)
 
 	charCount := 0
-	var laserX, laserY float64
-	laserCaptured := false
 
 	// Draw tokens
 	for _, token := range tokens {
+		dc.SetFontFace(faces.forStyle(token.Style))
+		underline := token.Style.Underline == chroma.Yes
+
 		for _, ch := range token.Text {
 			if !r.config.LaserReveal && charCount >= cursorPos {
 				break
 			}
 
-			// Capture exact coordinate of the laser
-			if charCount == cursorPos {
-				laserX = x
-				laserY = y
-				laserCaptured = true
+			g := layout.glyphs[charCount]
+			charCount++
+
+			// Newlines and tabs only move the pen, which the layout has done
+			if ch == '\n' || ch == '\t' {
+				continue
 			}
 
-			// Handle newlines
-			if ch == '\n' {
-				x = float64(r.config.Padding) + shadowOffset + gutterWidth
-				y += r.config.FontSize * r.config.LineHeight
-				charCount++
+			x := originX + g.X
+			y := originY + float64(g.Row)*rowHeight
+
+			// Skip rows wholly outside the viewport and, when clipping,
+			// anything past the right padding
+			if top := y - r.config.FontSize; top+rowHeight <= viewTop || top >= viewBottom {
+				continue
+			}
+			if r.config.Wrap == WrapClip && x+g.Width > rightEdge {
 				continue
 			}
 
 			// Set color from token style
-			textColor := tokenColor(token)
+			textColor := r.tokenColor(token)
 
 			// Scanner Laser Opacity Calculation
 			if r.config.LaserReveal {
-				diff := charCount - cursorPos
+				diff := charCount - 1 - cursorPos
 
 				opacity := 255.0
 				if diff > 0 {
@@ -175,9 +318,6 @@ func (r *Renderer) RenderFrame(tokens []highlight.Token, cursorPos int, showCurs
 				}
 
 				if opacity <= 0 {
-					w, _ := dc.MeasureString(string(ch))
-					x += w
-					charCount++
 					continue
 				}
 
@@ -187,15 +327,13 @@ func (r *Renderer) RenderFrame(tokens []highlight.Token, cursorPos int, showCurs
 			}
 
 			dc.SetColor(textColor)
-
-			// Draw character
 			dc.DrawString(string(ch), x, y)
 
-			// Move x position
-			w, _ := dc.MeasureString(string(ch))
-			x += w
-
-			charCount++
+			if underline {
+				thickness := math.Max(1, r.config.FontSize/14)
+				dc.DrawRectangle(x, y+2*r.config.ScaleFactor, g.Width, thickness)
+				dc.Fill()
+			}
 		}
 
 		if !r.config.LaserReveal && charCount >= cursorPos {
@@ -203,12 +341,12 @@ func (r *Renderer) RenderFrame(tokens []highlight.Token, cursorPos int, showCurs
 		}
 	}
 
-	// Capture laser position if it's past the very last character
-	if charCount == cursorPos {
-		laserX = x
-		laserY = y
-		laserCaptured = true
-	}
+	// The cursor and laser sit where the next rune would be drawn
+	cursor := layout.at(cursorPos)
+	x := originX + cursor.X
+	y := originY + float64(cursor.Row)*rowHeight
+	laserX, laserY := x, y
+	laserCaptured := cursorPos <= len(layout.glyphs)
 
 	// Draw cursor / Laser
 	if showCursor && cursorPos <= totalChars(tokens) {
@@ -233,25 +371,106 @@ func (r *Renderer) RenderFrame(tokens []highlight.Token, cursorPos int, showCurs
 		}
 	}
 
-	return dc.Image().(*image.RGBA), nil
+	img := dc.Image().(*image.RGBA)
+	if saved != nil {
+		r.restoreOutsideWindow(img, saved, shadowOffset, viewTop)
+	}
+	return img, nil
+}
+
+// cloneRGBA returns a copy of img
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	out := *img
+	out.Pix = append([]uint8(nil), img.Pix...)
+	return &out
+}
+
+// restoreOutsideWindow copies back from saved every pixel outside the
+// window's rounded body or above clipTop, which clips what was drawn since
+func (r *Renderer) restoreOutsideWindow(img, saved *image.RGBA, offset, clipTop float64) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[y*img.Stride : (y+1)*img.Stride]
+		orig := saved.Pix[y*saved.Stride : (y+1)*saved.Stride]
+		x0, x1, ok := r.windowSpan(y, offset, clipTop)
+		if !ok {
+			copy(row, orig)
+			continue
+		}
+		x0, x1 = max(0, min(b.Max.X, x0)), max(0, min(b.Max.X, x1))
+		copy(row[:x0*4], orig[:x0*4])
+		copy(row[x1*4:], orig[x1*4:])
+	}
+}
+
+// windowSpan returns the columns [x0, x1) of pixel row y whose centres
+// are inside the window's rounded body, or false when the row is above
+// clipTop or outside the body altogether
+func (r *Renderer) windowSpan(y int, offset, clipTop float64) (x0, x1 int, ok bool) {
+	left, right := offset, offset+float64(r.config.Width)
+	top, bottom := offset, offset+float64(r.config.Height)
+	radius := r.config.CornerRadius
+
+	yc := float64(y) + 0.5
+	if yc < max(top, clipTop) || yc > bottom {
+		return 0, 0, false
+	}
+
+	// How far the rounded corners pull the window edge in on this row
+	inset := 0.0
+	if d := math.Max(top+radius-yc, yc-(bottom-radius)); d > 0 {
+		inset = radius - math.Sqrt(math.Max(0, radius*radius-d*d))
+	}
+	x0 = int(math.Ceil(left + inset))
+	return x0, max(x0, int(math.Floor(right-inset))), true
+}
+
+// drawTokenBackgrounds paints the background colour of revealed tokens
+// whose style sets one different from the theme's own background, such as
+// diff insertions and deletions or error tokens
+func (r *Renderer) drawTokenBackgrounds(dc *gg.Context, tokens []highlight.Token, layout *textLayout, cursorPos int, originX, originY float64) {
+	rowHeight := r.config.FontSize * r.config.LineHeight
+	top := originY - r.config.FontSize + 5*r.config.ScaleFactor - (rowHeight-r.config.FontSize)/2
+
+	charCount := 0
+	for _, token := range tokens {
+		n := len([]rune(token.Text))
+		bg := token.Style.Background
+		if !bg.IsSet() || bg == r.config.ThemeBackground {
+			charCount += n
+			continue
+		}
+
+		dc.SetColor(chromaColor(bg))
+
+		// Merge the token's glyphs into one rectangle per row so adjacent
+		// cells do not leave anti-aliased seams between them
+		spanRow, spanStart, spanEnd := -1, 0.0, 0.0
+		flush := func() {
+			if spanRow >= 0 && spanEnd > spanStart {
+				dc.DrawRectangle(originX+spanStart, top+float64(spanRow)*rowHeight, spanEnd-spanStart, rowHeight)
+				dc.Fill()
+			}
+		}
+		for i := charCount; i < charCount+n && i < cursorPos; i++ {
+			g := layout.glyphs[i]
+			if g.Row != spanRow {
+				flush()
+				spanRow, spanStart = g.Row, g.X
+			}
+			spanEnd = g.X + g.Width
+		}
+		flush()
+		charCount += n
+	}
 }
 
 // drawGradientBackground draws a gradient background with rounded corners and shadow
 func (r *Renderer) drawGradientBackground(dc *gg.Context, offset float64, progress float64) {
 	// Draw multi-layered soft shadow for cinematic depth
 	if r.config.ShadowEnabled {
-		// Glow tint based on theme (simplified syntax-aware glow)
-		glowColor := color.RGBA{0, 240, 255, 10} // default neon cyan glow
-		if r.config.Theme == "dracula" {
-			glowColor = color.RGBA{255, 121, 198, 10} // pink glow
-		} else if r.config.Theme == "monokai" {
-			glowColor = color.RGBA{253, 151, 31, 10} // orange glow
-		} else if r.config.Theme == "nord" {
-			glowColor = color.RGBA{136, 192, 208, 10} // ice blue glow
-		}
-
 		// Layer 1: Ambient large glow (colored)
-		dc.SetColor(glowColor)
+		dc.SetColor(r.glowColor())
 		dc.DrawRoundedRectangle(
 			offset-4*r.config.ScaleFactor,
 			offset+12*r.config.ScaleFactor,
@@ -284,10 +503,7 @@ func (r *Renderer) drawGradientBackground(dc *gg.Context, offset float64, progre
 		dc.Fill()
 	}
 
-	// Draw gradient background
-	// Simulate deep space gradient with animated breathing
-	gradient1 := color.RGBA{13, 14, 21, 255} // #0D0E15 Base Canvas
-	gradient2 := color.RGBA{22, 24, 33, 255} // #161821 Inner Glow
+	gradient1, gradient2 := r.bodyColors()
 
 	// Draw base rectangle
 	dc.SetColor(gradient1)
@@ -302,47 +518,27 @@ func (r *Renderer) drawGradientBackground(dc *gg.Context, offset float64, progre
 
 	// Kinetic Typographic Background
 	if r.config.Language != "" {
-		dc.Push()
-
-		// Massive font size
-		bgFontSize := r.config.FontSize * 8.0
-		face := truetype.NewFace(r.font, &truetype.Options{
-			Size: bgFontSize,
-		})
-		dc.SetFontFace(face)
-
-		// Very faint, dark text
-		dc.SetColor(color.RGBA{0, 0, 0, 40})
-
-		// Scroll vertically based on progress
-		textY := float64(r.config.Height) - (progress * float64(r.config.Height) * 0.5)
-		textX := offset + float64(r.config.Padding)
-
-		// Rotate slightly for dynamic feel
-		dc.RotateAbout(math.Pi/12, offset+float64(r.config.Width)/2, offset+float64(r.config.Height)/2)
-
-		// Draw the language name in uppercase
-		dc.DrawString(strings.ToUpper(r.config.Language), textX, textY)
-
-		dc.Pop()
+		r.drawWatermark(dc.Image().(*image.RGBA), offset, progress)
 	}
 
-	// Breathing effect: vary the height and position of the inner glow
-	breathOffset := math.Sin(progress*math.Pi*2) * 10 * r.config.ScaleFactor
+	if r.config.Background == BackgroundGradient {
+		// Breathing effect: vary the height and position of the inner glow
+		breathOffset := math.Sin(progress*math.Pi*2) * 10 * r.config.ScaleFactor
 
-	// Draw subtle overlay for gradient effect
-	dc.SetColor(gradient2)
-	dc.DrawRoundedRectangle(
-		offset,
-		offset+float64(r.config.Height)*0.15+breathOffset,
-		float64(r.config.Width),
-		float64(r.config.Height)*0.85-breathOffset,
-		r.config.CornerRadius,
-	)
-	dc.Fill()
+		// Draw subtle overlay for gradient effect
+		dc.SetColor(gradient2)
+		dc.DrawRoundedRectangle(
+			offset,
+			offset+float64(r.config.Height)*0.15+breathOffset,
+			float64(r.config.Width),
+			float64(r.config.Height)*0.85-breathOffset,
+			r.config.CornerRadius,
+		)
+		dc.Fill()
+	}
 
 	// The Ghost Outline (1px inner ring)
-	dc.SetColor(color.RGBA{255, 255, 255, 20}) // 8% opacity white
+	dc.SetColor(r.contrast(20)) // 8% opacity
 	dc.DrawRoundedRectangle(
 		offset+0.5,
 		offset+0.5,
@@ -354,6 +550,35 @@ func (r *Renderer) drawGradientBackground(dc *gg.Context, offset float64, progre
 	dc.Stroke()
 }
 
+// glowColor is the tint of the outer shadow layer (simplified
+// syntax-aware glow)
+func (r *Renderer) glowColor() color.RGBA {
+	switch r.config.Theme {
+	case "dracula":
+		return color.RGBA{255, 121, 198, 10} // pink glow
+	case "monokai":
+		return color.RGBA{253, 151, 31, 10} // orange glow
+	case "nord":
+		return color.RGBA{136, 192, 208, 10} // ice blue glow
+	}
+	return color.RGBA{0, 240, 255, 10} // default neon cyan glow
+}
+
+// bodyColors returns the colour of the window body and of the inner glow
+// drawn over it. Both are the theme's background, except that the
+// gradient style darkens the base and lets a lighter inner glow breathe
+// over it.
+func (r *Renderer) bodyColors() (base, glow color.Color) {
+	if r.config.Background != BackgroundGradient {
+		return r.config.BgColor, r.config.BgColor
+	}
+	darken := 0.06
+	if r.config.Dark {
+		darken = 0.4
+	}
+	return mix(r.config.BgColor.(color.RGBA), color.RGBA{0, 0, 0, 255}, darken), r.config.BgColor
+}
+
 // drawMacOSChrome draws macOS-style window controls
 func (r *Renderer) drawMacOSChrome(dc *gg.Context, offset float64) {
 	y := offset + 20.0*r.config.ScaleFactor
@@ -382,23 +607,24 @@ func (r *Renderer) drawMacOSChrome(dc *gg.Context, offset float64) {
 // drawWindowsChrome draws Windows-style window controls
 func (r *Renderer) drawWindowsChrome(dc *gg.Context, offset float64) {
 	// Draw title bar
-	dc.SetColor(color.RGBA{30, 30, 30, 255})
+	darken := 0.08
+	if r.config.Dark {
+		darken = 0.3
+	}
+	dc.SetColor(mix(r.config.BgColor.(color.RGBA), color.RGBA{0, 0, 0, 255}, darken))
 	dc.DrawRectangle(offset, offset, float64(r.config.Width), 40*r.config.ScaleFactor)
 	dc.Fill()
 
 	// Draw window controls (simplified)
 	x := offset + float64(r.config.Width) - 40*r.config.ScaleFactor
 	y := offset + 20.0*r.config.ScaleFactor
-	dc.SetColor(color.RGBA{200, 200, 200, 255})
+	dc.SetColor(r.config.LineNumColor)
 	dc.DrawRectangle(x-30*r.config.ScaleFactor, y-6*r.config.ScaleFactor, 12*r.config.ScaleFactor, 12*r.config.ScaleFactor)
 	dc.StrokePreserve()
 }
 
 // drawLineHighlights draws highlight backgrounds and line numbers for specified lines
-func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token, cursorPos int, offset float64, gutterWidth float64) {
-	currentLine := 1
-	charCount := 0
-
+func (r *Renderer) drawLineHighlights(dc *gg.Context, layout *textLayout, cursorPos int, offset float64, gutterWidth float64, scroll float64) {
 	// Pre-calculate line heights for positioning
 	chromeHeight := 0.0
 	if r.config.WindowStyle == "macos" || r.config.WindowStyle == "windows" {
@@ -406,12 +632,12 @@ func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token,
 	}
 
 	// Start y position aligned with the text baseline, adjusted back up to bounds
-	y := offset + float64(r.config.Padding) + chromeHeight - 5*r.config.ScaleFactor
+	y := offset + float64(r.config.Padding) + chromeHeight - 5*r.config.ScaleFactor - scroll
 	accentColor := color.RGBA{0, 240, 255, 255} // Neon Cyan
 	highlightHeight := r.config.FontSize * r.config.LineHeight
 
-	drawNumbersAndHighlights := func(line int, currentY float64) {
-		// Draw highlight if enabled
+	drawNumbersAndHighlights := func(line int, currentY float64, rows int) {
+		// Draw highlight if enabled, covering every wrapped row of the line
 		if r.config.HighlightLines[line] {
 			// 1. Draw subtle background wash
 			dc.SetColor(r.config.HighlightColor)
@@ -419,7 +645,7 @@ func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token,
 				offset,
 				currentY,
 				float64(r.config.Width),
-				highlightHeight,
+				highlightHeight*float64(rows),
 			)
 			dc.Fill()
 
@@ -429,7 +655,7 @@ func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token,
 				offset,
 				currentY,
 				4.0*r.config.ScaleFactor, // 4px wide accent
-				highlightHeight,
+				highlightHeight*float64(rows),
 			)
 			dc.Fill()
 		}
@@ -438,15 +664,11 @@ func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token,
 		if r.config.LineNumbers {
 			numStr := fmt.Sprintf("%2d", line)
 
-			// Load slightly smaller font for line numbers
-			numberSize := r.config.FontSize * 0.8
-			face := truetype.NewFace(r.font, &truetype.Options{
-				Size: numberSize,
-			})
-			dc.SetFontFace(face)
+			// Slightly smaller font for line numbers
+			dc.SetFontFace(r.faces.lineNumber)
 
-			// Faint white for line numbers
-			dc.SetColor(color.RGBA{255, 255, 255, 100})
+			// Faint theme color for line numbers
+			dc.SetColor(r.config.LineNumColor)
 
 			// Position number in the gutter
 			numX := offset + float64(r.config.Padding)
@@ -455,7 +677,7 @@ func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token,
 
 			// Draw 1px vertical separator line at the right edge of gutter
 			if line == 1 {
-				dc.SetColor(color.RGBA{255, 255, 255, 15})
+				dc.SetColor(r.contrast(15))
 				sepX := offset + float64(r.config.Padding) + gutterWidth - (15.0 * r.config.ScaleFactor)
 				dc.DrawLine(sepX, offset+chromeHeight+float64(r.config.Padding), sepX, float64(r.config.Height)-float64(r.config.Padding))
 				dc.SetLineWidth(1.0 * r.config.ScaleFactor)
@@ -463,49 +685,46 @@ func (r *Renderer) drawLineHighlights(dc *gg.Context, tokens []highlight.Token,
 			}
 
 			// Restore normal font size
-			normalFace := truetype.NewFace(r.font, &truetype.Options{
-				Size: r.config.FontSize,
-			})
-			dc.SetFontFace(normalFace)
+			dc.SetFontFace(r.faces.regular)
 		}
 	}
 
-	// Draw the first line immediately
-	drawNumbersAndHighlights(currentLine, y)
-
-	for _, token := range tokens {
-		for _, ch := range token.Text {
-			if charCount >= cursorPos {
-				return
-			}
+	// Each line appears once the cursor has passed the newline before it;
+	// line numbers only go on the first visual row of a wrapped line
+	for i := 0; i < layout.lines; i++ {
+		if i > 0 && layout.lineEnds[i-1] >= cursorPos {
+			return
+		}
 
-			if ch == '\n' {
-				currentLine++
-				y += highlightHeight
-				drawNumbersAndHighlights(currentLine, y)
-			}
-			charCount++
+		rows := layout.rows - layout.lineRows[i]
+		if i+1 < layout.lines {
+			rows = layout.lineRows[i+1] - layout.lineRows[i]
 		}
+		drawNumbersAndHighlights(i+1, y+float64(layout.lineRows[i])*highlightHeight, rows)
 	}
 }
 
 // tokenColor converts a chroma style to a color
-func tokenColor(token highlight.Token) color.Color {
+func (r *Renderer) tokenColor(token highlight.Token) color.Color {
 	style := token.Style
 
 	// Use foreground color if available
 	if style.Colour.IsSet() {
-		c := style.Colour
-		return color.RGBA{
-			R: uint8((c >> 16) & 0xFF),
-			G: uint8((c >> 8) & 0xFF),
-			B: uint8(c & 0xFF),
-			A: 255,
-		}
+		return chromaColor(style.Colour)
 	}
 
-	// Default to white
-	return color.RGBA{248, 248, 242, 255} // Dracula foreground
+	// Default to the theme's text color
+	return r.config.TextColor
+}
+
+// chromaColor converts a chroma colour to an opaque RGBA color
+func chromaColor(c chroma.Colour) color.RGBA {
+	return color.RGBA{
+		R: c.Red(),
+		G: c.Green(),
+		B: c.Blue(),
+		A: 255,
+	}
 }
 
 // totalChars counts total characters in tokens
@@ -519,20 +738,80 @@ func totalChars(tokens []highlight.Token) int {
 
 // CalculateHeight calculates the required height based on content
 func (r *Renderer) CalculateHeight(tokens []highlight.Token) int {
-	lines := 1
-	for _, token := range tokens {
-		for _, ch := range token.Text {
-			if ch == '\n' {
-				lines++
-			}
-		}
-	}
+	rows := r.measure(tokens).rows
 
 	chromeHeight := 0.0
 	if r.config.WindowStyle == "macos" || r.config.WindowStyle == "windows" {
 		chromeHeight = 40.0 * r.config.ScaleFactor
 	}
 
-	height := int(float64(r.config.Padding)*2 + float64(lines)*r.config.FontSize*r.config.LineHeight + chromeHeight)
+	height := int(math.Ceil(float64(r.config.Padding)*2 + float64(rows)*r.config.FontSize*r.config.LineHeight + chromeHeight))
 	return height
 }
+
+// FitContent sizes the canvas to the fully revealed tokens, clamped to the
+// configured minimum and maximum heights. Content taller than the maximum
+// is an error unless the overflow mode allows clipping.
+func (r *Renderer) FitContent(tokens []highlight.Token) error {
+	height := r.CalculateHeight(tokens)
+
+	if r.config.MinHeight > 0 && height < r.config.MinHeight {
+		height = r.config.MinHeight
+	}
+
+	if r.config.MaxHeight > 0 && height > r.config.MaxHeight {
+		if r.config.Overflow == OverflowError {
+			return fmt.Errorf("code needs %dpx for %d rows but max height is %dpx (use a smaller font size, raise --max-height or pass --overflow %s)",
+				int(float64(height)/r.config.ScaleFactor), r.measure(tokens).rows, int(float64(r.config.MaxHeight)/r.config.ScaleFactor), OverflowClip)
+		}
+		height = r.config.MaxHeight
+	}
+
+	r.config.Height = height
+	return nil
+}
+
+// Height returns the current canvas height, excluding the shadow margin
+func (r *Renderer) Height() int {
+	return r.config.Height
+}
+
+// measure returns the layout of the tokens. The layout only depends on
+// the tokens and settings fixed at construction, so it is computed once
+// and reused for every frame of the same tokens. Frames pass the same
+// slice, which is taken as unchanged without looking at its text; another
+// slice is hashed, so equal screens of a recording share a layout.
+func (r *Renderer) measure(tokens []highlight.Token) *textLayout {
+	if r.layout != nil && sameSlice(tokens, r.layoutTokens) {
+		return r.layout
+	}
+	if key := tokensKey(tokens); r.layout == nil || key != r.layoutKey {
+		r.layout = r.layoutText(tokens, r.faces)
+		r.layoutKey = key
+	}
+	r.layoutTokens = tokens
+	return r.layout
+}
+
+// sameSlice reports whether a and b are the same slice of tokens
+func sameSlice(a, b []highlight.Token) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// layoutSeed keys the layout cache, the same for every renderer
+var layoutSeed = maphash.MakeSeed()
+
+// tokensKey hashes what the layout of tokens depends on: their text and
+// the face each is drawn with
+func tokensKey(tokens []highlight.Token) uint64 {
+	var h maphash.Hash
+	h.SetSeed(layoutSeed)
+	var buf [9]byte
+	for _, token := range tokens {
+		binary.LittleEndian.PutUint64(buf[:8], uint64(len(token.Text)))
+		buf[8] = byte(token.Style.Bold)<<2 | byte(token.Style.Italic)
+		h.Write(buf[:])
+		h.WriteString(token.Text)
+	}
+	return h.Sum64()
+}
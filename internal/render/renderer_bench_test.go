@@ -0,0 +1,120 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// benchCode is a mid-sized snippet with long lines, so soft wrapping and
+// line numbers are exercised as well
+var benchCode = strings.Repeat(`// Fetch retrieves a document by id and decodes it into the target value, retrying on transient errors
+func (c *Client) Fetch(ctx context.Context, id string, target any) error {
+	for attempt := 0; attempt < c.maxRetries; attempt++ {
+		resp, err := c.http.Get(fmt.Sprintf("%s/docs/%s", c.baseURL, id))
+		if err != nil {
+			continue
+		}
+		defer resp.Body.Close()
+		return json.NewDecoder(resp.Body).Decode(target)
+	}
+	return ErrTooManyRetries
+}
+
+`, 3)
+
+// newBenchRenderer sets up a renderer for benchCode; a maxHeight above 0
+// turns on the scrolling viewport
+func newBenchRenderer(b *testing.B, maxHeight int) (*Renderer, []highlight.Token) {
+	b.Helper()
+	overflow := OverflowError
+	if maxHeight > 0 {
+		overflow = OverflowScroll
+	}
+	code, err := highlight.Highlight(benchCode, "go", "dracula")
+	if err != nil {
+		b.Fatal(err)
+	}
+	r, err := NewRenderer(Options{
+		Width:       800,
+		FontSize:    16,
+		WindowStyle: "macos",
+		LineNumbers: true,
+		Language:    "go",
+		LaserReveal: true,
+		Wrap:        WrapSoft,
+		Style:       code.Style,
+		MaxHeight:   maxHeight,
+		Overflow:    overflow,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := r.FitContent(code.Tokens); err != nil {
+		b.Fatal(err)
+	}
+	return r, code.Tokens
+}
+
+// uncache throws away the layout, watermark and decorative faces the
+// renderer keeps between frames, so the next frame pays for them as it
+// did before they were cached. The "uncached" sub-benchmarks compare against that:
+//
+//	go test -run '^$' -bench . ./internal/render
+func uncache(r *Renderer) {
+	r.layout = nil
+	r.watermark = nil
+	r.faces.lineNumber = newFace(r.fonts.Regular, r.config.FontSize*lineNumberScale)
+	r.faces.kinetic = newFace(r.fonts.Bold, r.config.FontSize*kineticScale)
+}
+
+// cacheModes runs a benchmark with and without the renderer's caches
+var cacheModes = []struct {
+	name   string
+	cached bool
+}{
+	{"cached", true},
+	{"uncached", false},
+}
+
+// BenchmarkRenderFrame renders frames across the whole reveal and reports
+// the rate in frames per second
+func BenchmarkRenderFrame(b *testing.B) {
+	for _, mode := range cacheModes {
+		b.Run(mode.name, func(b *testing.B) {
+			r, tokens := newBenchRenderer(b, 0)
+			total := totalChars(tokens)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !mode.cached {
+					uncache(r)
+				}
+				pos := (i * 37) % (total + 1)
+				if _, err := r.RenderFrame(tokens, pos, i%2 == 0, float64(pos)/float64(total)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+		})
+	}
+}
+
+// BenchmarkCursorScroll measures planning the camera for one frame
+func BenchmarkCursorScroll(b *testing.B) {
+	for _, mode := range cacheModes {
+		b.Run(mode.name, func(b *testing.B) {
+			r, tokens := newBenchRenderer(b, 300)
+			total := totalChars(tokens)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !mode.cached {
+					uncache(r)
+				}
+				r.CursorScroll(tokens, (i*37)%(total+1))
+			}
+		})
+	}
+}
@@ -0,0 +1,535 @@
+package render
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// svgFallbackFonts follow the layout font in SVG font-family lists, for
+// viewers that do not have it installed
+const svgFallbackFonts = "ui-monospace, SFMono-Regular, Menlo, Consolas, monospace"
+
+// SVGFrame is the state of the code in one frame of an SVG animation
+type SVGFrame struct {
+	CursorPos  int
+	ShowCursor bool
+	Progress   float64
+	Scroll     float64
+}
+
+// SVGAnimation is the plan WriteSVG animates: frames shown one after
+// another at FPS, played Plays times (0 = forever). Viewers that do not
+// animate SVG show the Poster frame, which also sets where the background
+// is drawn.
+type SVGAnimation struct {
+	Frames []SVGFrame
+	Poster SVGFrame
+	FPS    int
+	Plays  int
+}
+
+// WriteSVG writes an animated SVG of the tokens. The code is real text,
+// laid out exactly as RenderFrame draws it, so it stays sharp at any zoom
+// and can be selected and copied. Each row is revealed by a clip whose
+// width steps along with the cursor, using SMIL animations that share
+// one timeline.
+//
+// The background, which only drifts slowly in raster frames, is drawn
+// once as the poster shows it, and the laser reveal is a hard edge
+// rather than a fade.
+func (r *Renderer) WriteSVG(w io.Writer, tokens []highlight.Token, anim SVGAnimation) error {
+	if len(anim.Frames) == 0 {
+		return fmt.Errorf("no frames to animate")
+	}
+	if anim.FPS <= 0 {
+		return fmt.Errorf("fps must be positive, got %d", anim.FPS)
+	}
+
+	bw := bufio.NewWriter(w)
+	s := &svgWriter{
+		w:      bw,
+		frames: len(anim.Frames),
+		dur:    float64(len(anim.Frames)) / float64(anim.FPS),
+		plays:  anim.Plays,
+	}
+
+	layout := r.measure(tokens)
+	sf := r.config.ScaleFactor
+	offset := 20.0 * sf // Shadow margin, as in RenderFrameScrolled
+	width := float64(r.config.Width) + 2*offset
+	height := float64(r.config.Height) + 2*offset
+
+	chromeHeight := 0.0
+	if r.config.WindowStyle == "macos" || r.config.WindowStyle == "windows" {
+		chromeHeight = 40.0 * sf
+	}
+	rowHeight := r.lineHeight()
+	originX := float64(r.config.Padding) + offset + r.gutterWidth()
+	originY := float64(r.config.Padding) + r.config.FontSize + offset + chromeHeight
+	rowTop := originY - r.config.FontSize + 5*sf - (rowHeight-r.config.FontSize)/2
+
+	// Sized in CSS pixels; HiDPI only adds detail to the view box
+	s.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s">`+"\n",
+		num(width/sf), num(height/sf), num(width), num(height))
+	family := strconv.Quote(r.fonts.Name) + ", " + svgFallbackFonts
+	s.printf("<style>text{font-family:%s;font-size:%spx;white-space:pre}</style>\n", escape(family), num(r.config.FontSize))
+
+	r.svgBackground(s, offset, anim.Poster.Progress)
+	switch r.config.WindowStyle {
+	case "macos":
+		r.svgMacOSChrome(s, offset)
+	case "windows":
+		r.svgWindowsChrome(s, offset)
+	}
+
+	// Code taller than the window, scrolled or cut off, must not spill
+	// over the title bar or out of the window
+	viewTop := offset + chromeHeight
+	viewBottom := offset + float64(r.config.Height)
+	clipView := r.config.Overflow == OverflowScroll || r.CalculateHeight(tokens) > r.config.Height
+	if clipView {
+		s.printf(`<clipPath id="view"><rect x="%s" y="%s" width="%s" height="%s"/></clipPath>`+"\n",
+			num(offset), num(viewTop), num(float64(r.config.Width)), num(float64(r.config.Height)-chromeHeight))
+		s.printf(`<g clip-path="url(#view)">` + "\n")
+	}
+	if r.config.LineNumbers {
+		sepX := offset + float64(r.config.Padding) + r.gutterWidth() - 15.0*sf
+		s.printf(`<line x1="%s" y1="%s" x2="%s" y2="%s" stroke-width="%s"%s/>`+"\n",
+			num(sepX), num(viewTop+float64(r.config.Padding)), num(sepX), num(float64(r.config.Height-r.config.Padding)),
+			num(sf), paint("stroke", r.contrast(15)))
+	}
+
+	// Rows that never scroll into the viewport are left out
+	minScroll, maxScroll := anim.Frames[0].Scroll, anim.Frames[0].Scroll
+	for _, f := range anim.Frames {
+		minScroll, maxScroll = min(minScroll, f.Scroll), max(maxScroll, f.Scroll)
+	}
+	visible := func(top, height float64) bool {
+		return top+height-minScroll > viewTop && top-maxScroll < viewBottom
+	}
+
+	// Everything below moves with the scroll
+	scroll := s.track(func(f SVGFrame) string { return "0 " + num(-f.Scroll) }, anim)
+	s.printf(`<g transform="translate(%s)">`, scroll.base)
+	s.animateTransform(scroll)
+	s.printf("\n")
+
+	r.svgLines(s, layout, anim, offset, chromeHeight, visible)
+
+	// One clip per row, as wide as the part the cursor has revealed
+	full := width - originX
+	for row := 0; row < layout.rows; row++ {
+		reveal := s.track(func(f SVGFrame) string {
+			cursor := layout.at(f.CursorPos)
+			switch {
+			case cursor.Row > row:
+				return num(full)
+			case cursor.Row == row:
+				return num(cursor.X)
+			}
+			return "0"
+		}, anim)
+
+		if !visible(rowTop+float64(row)*rowHeight, rowHeight) {
+			continue
+		}
+		body := r.svgRow(layout, tokens, row, originX, originY+float64(row)*rowHeight, rowTop+float64(row)*rowHeight)
+		if body == "" {
+			continue
+		}
+
+		// Italic glyphs may lean left of the first column
+		left := r.config.FontSize
+		s.printf(`<clipPath id="r%d"><rect x="%s" y="%s" width="%s" height="%s">`,
+			row, num(originX-left), num(rowTop+float64(row)*rowHeight), reveal.add(left), num(rowHeight))
+		s.animate("width", reveal.shift(left))
+		s.printf("</rect></clipPath>\n")
+		s.printf(`<g clip-path="url(#r%d)">%s</g>`+"\n", row, body)
+	}
+
+	r.svgCursor(s, layout, tokens, anim, originX, originY)
+
+	s.printf("</g>\n")
+	if clipView {
+		s.printf("</g>\n")
+	}
+	s.printf("</svg>\n")
+
+	if s.err != nil {
+		return s.err
+	}
+	return bw.Flush()
+}
+
+// svgRow returns the token backgrounds and text of one visual row, or ""
+// if the row has nothing to draw
+func (r *Renderer) svgRow(layout *textLayout, tokens []highlight.Token, row int, originX, baseline, top float64) string {
+	var bgs, text strings.Builder
+	rowHeight := r.lineHeight()
+	rightEdge := r.textAreaWidth()
+
+	for _, mark := range layout.wraps {
+		if mark.Row == row {
+			fmt.Fprintf(&text, `<tspan x="%s"%s>%s</tspan>`, num(originX+mark.X), paint("fill", r.config.LineNumColor), escape(string(r.wrapIndicator())))
+		}
+	}
+
+	i := 0
+	for _, token := range tokens {
+		runes := []rune(token.Text)
+		start := i
+		i += len(runes)
+		if len(runes) == 0 || layout.glyphs[start].Row > row || layout.glyphs[i-1].Row < row {
+			continue
+		}
+
+		// Runs of the token on this row, split at tabs and newlines, which
+		// only move the pen
+		var run []rune
+		runX, runEnd := 0.0, 0.0
+		flush := func() {
+			if strings.TrimSpace(string(run)) != "" {
+				fmt.Fprintf(&text, `<tspan x="%s"`, num(originX+runX))
+				if len(run) > 1 {
+					fmt.Fprintf(&text, ` textLength="%s"`, num(runEnd-runX))
+				}
+				text.WriteString(r.svgTokenStyle(token))
+				fmt.Fprintf(&text, ">%s</tspan>", escape(string(run)))
+			}
+			run = run[:0]
+		}
+		for j, ch := range runes {
+			g := layout.glyphs[start+j]
+			if g.Row != row || ch == '\n' || ch == '\t' || (r.config.Wrap == WrapClip && g.X+g.Width > rightEdge) {
+				flush()
+				continue
+			}
+			if len(run) == 0 {
+				runX = g.X
+			}
+			run = append(run, ch)
+			runEnd = g.X + g.Width
+		}
+		flush()
+
+		// Backgrounds merge the token's cells on the row into one rectangle
+		bg := token.Style.Background
+		if bg.IsSet() && bg != r.config.ThemeBackground {
+			lo, hi := math.Inf(1), math.Inf(-1)
+			for j := start; j < i; j++ {
+				if g := layout.glyphs[j]; g.Row == row {
+					lo, hi = math.Min(lo, g.X), math.Max(hi, g.X+g.Width)
+				}
+			}
+			if hi > lo {
+				fmt.Fprintf(&bgs, `<rect x="%s" y="%s" width="%s" height="%s"%s/>`,
+					num(originX+lo), num(top), num(hi-lo), num(rowHeight), paint("fill", chromaColor(bg)))
+			}
+		}
+	}
+
+	if text.Len() == 0 && bgs.Len() == 0 {
+		return ""
+	}
+	if text.Len() == 0 {
+		return bgs.String()
+	}
+	return fmt.Sprintf(`%s<text y="%s">%s</text>`, bgs.String(), num(baseline), text.String())
+}
+
+// svgTokenStyle returns the presentation attributes of a token's text
+func (r *Renderer) svgTokenStyle(token highlight.Token) string {
+	attrs := paint("fill", r.tokenColor(token))
+	if token.Style.Bold == chroma.Yes {
+		attrs += ` font-weight="bold"`
+	}
+	if token.Style.Italic == chroma.Yes {
+		attrs += ` font-style="italic"`
+	}
+	if token.Style.Underline == chroma.Yes {
+		attrs += ` text-decoration="underline"`
+	}
+	return attrs
+}
+
+// svgLines draws line highlights and numbers, each line appearing once
+// the cursor has passed the newline before it. Lines visible rejects are
+// left out.
+func (r *Renderer) svgLines(s *svgWriter, layout *textLayout, anim SVGAnimation, offset, chromeHeight float64, visible func(top, height float64) bool) {
+	if len(r.config.HighlightLines) == 0 && !r.config.LineNumbers {
+		return
+	}
+	sf := r.config.ScaleFactor
+	top := offset + float64(r.config.Padding) + chromeHeight - 5*sf
+	rowHeight := r.lineHeight()
+
+	for i := 0; i < layout.lines; i++ {
+		line := i + 1
+		if !r.config.HighlightLines[line] && !r.config.LineNumbers {
+			continue
+		}
+		rows := layout.rows - layout.lineRows[i]
+		if i+1 < layout.lines {
+			rows = layout.lineRows[i+1] - layout.lineRows[i]
+		}
+		y := top + float64(layout.lineRows[i])*rowHeight
+		if !visible(y, rowHeight*float64(rows)) {
+			continue
+		}
+
+		shown := s.track(func(f SVGFrame) string {
+			if i > 0 && layout.lineEnds[i-1] >= f.CursorPos {
+				return "hidden"
+			}
+			return "visible"
+		}, anim)
+		s.printf(`<g visibility="%s">`, shown.base)
+		s.animate("visibility", shown)
+		if r.config.HighlightLines[line] {
+			s.printf(`<rect x="%s" y="%s" width="%s" height="%s"%s/>`,
+				num(offset), num(y), num(float64(r.config.Width)), num(rowHeight*float64(rows)), paint("fill", r.config.HighlightColor))
+			s.printf(`<rect x="%s" y="%s" width="%s" height="%s"%s/>`,
+				num(offset), num(y), num(4.0*sf), num(rowHeight*float64(rows)), paint("fill", color.RGBA{0, 240, 255, 255}))
+		}
+		if r.config.LineNumbers {
+			s.printf(`<text x="%s" y="%s" font-size="%s"%s>%s</text>`,
+				num(offset+float64(r.config.Padding)), num(y+r.config.FontSize), num(r.config.FontSize*lineNumberScale),
+				paint("fill", r.config.LineNumColor), fmt.Sprintf("%2d", line))
+		}
+		s.printf("</g>\n")
+	}
+}
+
+// svgCursor draws the cursor, or the laser line, following the reveal
+func (r *Renderer) svgCursor(s *svgWriter, layout *textLayout, tokens []highlight.Token, anim SVGAnimation, originX, originY float64) {
+	total := totalChars(tokens)
+	shown := s.track(func(f SVGFrame) string {
+		if f.ShowCursor && f.CursorPos <= total && (!r.config.LaserReveal || f.CursorPos <= len(layout.glyphs)) {
+			return "visible"
+		}
+		return "hidden"
+	}, anim)
+	if shown.static() && shown.base == "hidden" {
+		return
+	}
+	at := s.track(func(f SVGFrame) string {
+		cursor := layout.at(f.CursorPos)
+		return num(originX+cursor.X) + " " + num(originY+float64(cursor.Row)*r.lineHeight())
+	}, anim)
+
+	s.printf(`<g visibility="%s" transform="translate(%s)">`, shown.base, at.base)
+	s.animate("visibility", shown)
+	s.animateTransform(at)
+
+	sf := r.config.ScaleFactor
+	top := -r.config.FontSize + 5*sf
+	if r.config.LaserReveal {
+		height := r.lineHeight()
+		s.printf(`<rect y="%s" width="%s" height="%s"%s/>`, num(top), num(2*sf), num(height), paint("fill", color.RGBA{0, 240, 255, 255}))
+		s.printf(`<rect x="%s" y="%s" width="%s" height="%s"%s/>`, num(0.5*sf), num(top), num(sf), num(height), paint("fill", color.RGBA{255, 255, 255, 200}))
+	} else {
+		s.printf(`<rect y="%s" width="%s" height="%s"%s/>`, num(top), num(10*sf), num(r.config.FontSize), paint("fill", r.config.CursorColor))
+	}
+	s.printf("</g>\n")
+}
+
+// svgBackground draws the shadow, window body and background language
+// name as drawGradientBackground does at progress
+func (r *Renderer) svgBackground(s *svgWriter, offset, progress float64) {
+	sf := r.config.ScaleFactor
+	w, h := float64(r.config.Width), float64(r.config.Height)
+	radius := r.config.CornerRadius
+	rect := func(x, y, w, h, radius float64, attrs string) {
+		s.printf(`<rect x="%s" y="%s" width="%s" height="%s" rx="%s"%s/>`+"\n", num(x), num(y), num(w), num(h), num(radius), attrs)
+	}
+
+	if r.config.ShadowEnabled {
+		rect(offset-4*sf, offset+12*sf, w+8*sf, h+8*sf, radius+4*sf, paint("fill", r.glowColor()))
+		rect(offset-2*sf, offset+8*sf, w+4*sf, h+4*sf, radius+2*sf, paint("fill", color.RGBA{0, 0, 0, 25}))
+		rect(offset, offset+4*sf, w, h, radius, paint("fill", color.RGBA{0, 0, 0, 50}))
+	}
+
+	base, glow := r.bodyColors()
+	rect(offset, offset, w, h, radius, paint("fill", base))
+
+	if r.config.Language != "" {
+		alpha := uint8(12)
+		if r.config.Dark {
+			alpha = 40
+		}
+		// Clipped to the window, as the rotated text overhangs it
+		s.printf(`<clipPath id="window"><rect x="%s" y="%s" width="%s" height="%s" rx="%s"/></clipPath>`+"\n",
+			num(offset), num(offset), num(w), num(h), num(radius))
+		s.printf(`<g clip-path="url(#window)"><text x="%s" y="%s" font-size="%spx" font-weight="bold" transform="rotate(15 %s %s)"%s>%s</text></g>`+"\n",
+			num(offset+float64(r.config.Padding)), num(h-progress*h*0.5), num(r.config.FontSize*kineticScale),
+			num(offset+w/2), num(offset+h/2), paint("fill", color.NRGBA{0, 0, 0, alpha}), escape(strings.ToUpper(r.config.Language)))
+	}
+
+	if r.config.Background == BackgroundGradient {
+		breath := math.Sin(progress*math.Pi*2) * 10 * sf
+		rect(offset, offset+h*0.15+breath, w, h*0.85-breath, radius, paint("fill", glow))
+	}
+
+	rect(offset+0.5, offset+0.5, w-sf, h-sf, radius, ` fill="none" stroke-width="`+num(sf)+`"`+paint("stroke", r.contrast(20)))
+}
+
+// svgMacOSChrome draws the macOS window controls
+func (r *Renderer) svgMacOSChrome(s *svgWriter, offset float64) {
+	sf := r.config.ScaleFactor
+	x, y := offset+20*sf, offset+20*sf
+	for _, c := range []color.RGBA{{236, 106, 94, 255}, {244, 191, 79, 255}, {97, 197, 84, 255}} {
+		s.printf(`<circle cx="%s" cy="%s" r="%s"%s/>`+"\n", num(x), num(y), num(6*sf), paint("fill", c))
+		x += 20 * sf
+	}
+}
+
+// svgWindowsChrome draws the Windows title bar and controls
+func (r *Renderer) svgWindowsChrome(s *svgWriter, offset float64) {
+	sf := r.config.ScaleFactor
+	darken := 0.08
+	if r.config.Dark {
+		darken = 0.3
+	}
+	bar := mix(r.config.BgColor.(color.RGBA), color.RGBA{0, 0, 0, 255}, darken)
+	s.printf(`<rect x="%s" y="%s" width="%s" height="%s"%s/>`+"\n", num(offset), num(offset), num(float64(r.config.Width)), num(40*sf), paint("fill", bar))
+	x := offset + float64(r.config.Width) - 70*sf
+	s.printf(`<rect x="%s" y="%s" width="%s" height="%s" fill="none"%s/>`+"\n", num(x), num(offset+14*sf), num(12*sf), num(12*sf), paint("stroke", r.config.LineNumColor))
+}
+
+// svgWriter writes SVG markup, keeping the first write error, and times
+// animations on the shared timeline
+type svgWriter struct {
+	w      io.Writer
+	err    error
+	frames int     // Frames on the timeline
+	dur    float64 // Seconds per play
+	plays  int     // 0 = forever
+}
+
+func (s *svgWriter) printf(format string, args ...any) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, format, args...)
+	}
+}
+
+// svgTrack is the value an attribute takes over the frames, kept as the
+// frames where it changes. Base is its value in the poster frame.
+type svgTrack struct {
+	base   string
+	frames []int
+	values []string
+}
+
+// track evaluates value for every frame, and for the poster
+func (s *svgWriter) track(value func(SVGFrame) string, anim SVGAnimation) svgTrack {
+	t := svgTrack{base: value(anim.Poster)}
+	for i, f := range anim.Frames {
+		v := value(f)
+		if n := len(t.values); n == 0 || t.values[n-1] != v {
+			t.frames = append(t.frames, i)
+			t.values = append(t.values, v)
+		}
+	}
+	return t
+}
+
+// static reports whether the value is the same in every frame and the poster
+func (t svgTrack) static() bool {
+	return len(t.values) == 1 && t.values[0] == t.base
+}
+
+// add returns a numeric base value increased by d
+func (t svgTrack) add(d float64) string {
+	v, _ := strconv.ParseFloat(t.base, 64)
+	return num(v + d)
+}
+
+// shift returns a numeric track with every value increased by d
+func (t svgTrack) shift(d float64) svgTrack {
+	out := svgTrack{base: t.add(d), frames: t.frames, values: make([]string, len(t.values))}
+	for i, v := range t.values {
+		f, _ := strconv.ParseFloat(v, 64)
+		out.values[i] = num(f + d)
+	}
+	return out
+}
+
+// animate writes a SMIL animation stepping attr through the track
+func (s *svgWriter) animate(attr string, t svgTrack) {
+	if t.static() {
+		return
+	}
+	s.printf(`<animate attributeName="%s" %s/>`, attr, s.timing(t))
+}
+
+// animateTransform writes a SMIL animation stepping a translate through
+// the track
+func (s *svgWriter) animateTransform(t svgTrack) {
+	if t.static() {
+		return
+	}
+	s.printf(`<animateTransform attributeName="transform" type="translate" %s/>`, s.timing(t))
+}
+
+// timing returns the values and timing attributes of a discrete
+// animation. Once the last play ends the final frame stays.
+func (s *svgWriter) timing(t svgTrack) string {
+	times := make([]string, len(t.frames))
+	for i, f := range t.frames {
+		times[i] = strconv.FormatFloat(float64(f)/float64(s.frames), 'f', -1, 64)
+		if len(times[i]) > 8 {
+			times[i] = times[i][:8]
+		}
+	}
+	repeat := "indefinite"
+	if s.plays > 0 {
+		repeat = strconv.Itoa(s.plays)
+	}
+	return fmt.Sprintf(`values="%s" keyTimes="%s" dur="%ss" calcMode="discrete" repeatCount="%s" fill="freeze"`,
+		strings.Join(t.values, ";"), strings.Join(times, ";"), num(s.dur), repeat)
+}
+
+// paint returns an SVG fill or stroke attribute for c, with its opacity.
+// Translucent colours in this package are written as straight RGB with an
+// alpha, so color.RGBA values are taken that way too.
+func paint(attr string, c color.Color) string {
+	var n color.NRGBA
+	switch c := c.(type) {
+	case color.RGBA:
+		n = color.NRGBA(c)
+	case color.NRGBA:
+		n = c
+	default:
+		n = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+	out := fmt.Sprintf(` %s="#%02x%02x%02x"`, attr, n.R, n.G, n.B)
+	if n.A < 255 {
+		out += fmt.Sprintf(` %s-opacity="%s"`, attr, num(float64(n.A)/255))
+	}
+	return out
+}
+
+// num formats a coordinate with at most two decimals
+func num(v float64) string {
+	v = math.Round(v*100) / 100
+	if v == 0 {
+		return "0" // Not "-0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// escape returns s with XML special characters escaped
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
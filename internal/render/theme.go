@@ -0,0 +1,146 @@
+package render
+
+import (
+	"image/color"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/forbiddenlink/gif-my-code/internal/highlight"
+)
+
+// Background styles for the window body
+const (
+	BackgroundTheme    = "theme"    // Flat fill with the theme's background colour
+	BackgroundGradient = "gradient" // Breathing gradient derived from the theme's background
+)
+
+// themeColors are the colours taken from a chroma style. The defaults are
+// the original "deep space" look, used when a style leaves an entry unset.
+type themeColors struct {
+	Background color.RGBA
+	Text       color.RGBA
+	LineNumber color.NRGBA
+	Highlight  color.NRGBA
+	Cursor     color.NRGBA
+	Dark       bool
+}
+
+// defaultThemeColors is the palette for styles that set nothing useful
+var defaultThemeColors = themeColors{
+	Background: color.RGBA{15, 17, 26, 255},     // Deep Space Window Base (#0F111A)
+	Text:       color.RGBA{248, 248, 242, 255},  // Dracula foreground
+	LineNumber: color.NRGBA{255, 255, 255, 100}, // Faint white
+	Highlight:  color.NRGBA{255, 255, 255, 15},  // Subtle white wash
+	Cursor:     color.NRGBA{255, 255, 255, 220}, // Slightly more opaque
+	Dark:       true,
+}
+
+// colorsFromStyle derives the window colours from a chroma style's
+// Background, LineNumbers and LineHighlight entries
+func colorsFromStyle(style *chroma.Style) themeColors {
+	colors := defaultThemeColors
+	if style == nil {
+		return colors
+	}
+
+	base := style.Get(chroma.Background)
+	if base.Background.IsSet() {
+		colors.Background = chromaColor(base.Background)
+	}
+	colors.Dark = luminance(colors.Background) < 0.5
+	if base.Colour.IsSet() {
+		colors.Text = chromaColor(base.Colour)
+	} else if !colors.Dark {
+		colors.Text = color.RGBA{36, 41, 47, 255}
+	}
+
+	// Entries inherit from Background, so only a colour that differs from
+	// the base one was actually chosen by the theme
+	colors.LineNumber = withAlpha(colors.Text, 100)
+	if numbers := style.Get(chroma.LineNumbers); numbers.Colour.IsSet() && numbers.Colour != base.Colour {
+		colors.LineNumber = withAlpha(chromaColor(numbers.Colour), 255)
+	}
+
+	colors.Highlight = withAlpha(colors.Text, 15)
+	if line := style.Get(chroma.LineHighlight); line.Background.IsSet() && line.Background != base.Background {
+		colors.Highlight = withAlpha(chromaColor(line.Background), 255)
+	}
+
+	colors.Cursor = withAlpha(colors.Text, 220)
+	return colors
+}
+
+// contrast returns a faint colour that shows up against the background,
+// white on dark themes and black on light ones
+func (r *Renderer) contrast(alpha uint8) color.NRGBA {
+	if r.config.Dark {
+		return color.NRGBA{255, 255, 255, alpha}
+	}
+	return color.NRGBA{0, 0, 0, alpha}
+}
+
+// luminance returns the relative brightness of c from 0 (black) to 1 (white)
+func luminance(c color.RGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 255
+}
+
+// mix blends a towards b by t in [0, 1], keeping a's alpha
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	lerp := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*t)
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), a.A}
+}
+
+// withAlpha returns the opaque colour c at a new, non-premultiplied alpha
+func withAlpha(c color.RGBA, alpha uint8) color.NRGBA {
+	return color.NRGBA{c.R, c.G, c.B, alpha}
+}
+
+// ThemePalette returns the solid colours the renderer draws with for a
+// style and its tokens: the window background, text, line numbers, the
+// highlight band, cursor and every token foreground and background. The
+// encoder reserves exact palette entries for them so quantization never
+// shifts syntax colours.
+func ThemePalette(style *chroma.Style, tokens []highlight.Token) []color.RGBA {
+	colors := colorsFromStyle(style)
+	bg := colors.Background
+
+	palette := []color.RGBA{
+		bg,
+		colors.Text,
+		over(bg, colors.LineNumber),
+		over(bg, colors.Highlight),
+		over(bg, colors.Cursor),
+	}
+	seen := map[color.RGBA]bool{}
+	for _, c := range palette {
+		seen[c] = true
+	}
+	add := func(c chroma.Colour) {
+		if !c.IsSet() {
+			return
+		}
+		if rgba := chromaColor(c); !seen[rgba] {
+			seen[rgba] = true
+			palette = append(palette, rgba)
+		}
+	}
+	for _, token := range tokens {
+		add(token.Style.Colour)
+		add(token.Style.Background)
+	}
+	return palette
+}
+
+// WindowColors returns the default text and background colours the
+// renderer draws a style with
+func WindowColors(style *chroma.Style) (text, background chroma.Colour) {
+	colors := colorsFromStyle(style)
+	t, b := colors.Text, colors.Background
+	return chroma.NewColour(t.R, t.G, t.B), chroma.NewColour(b.R, b.G, b.B)
+}
+
+// over composites a translucent colour onto an opaque background
+func over(bg color.RGBA, c color.NRGBA) color.RGBA {
+	return mix(bg, color.RGBA{c.R, c.G, c.B, 255}, float64(c.A)/255)
+}
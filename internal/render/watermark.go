@@ -0,0 +1,103 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+// watermarkAngle tilts the background language name for a dynamic feel
+const watermarkAngle = math.Pi / 12
+
+// watermark is the rotated background language name, rasterised once as
+// a coverage mask where it sits at the start of the animation. Frames
+// only slide it along the tilted axis, so drawing it costs a blend over
+// its own bounds rather than a rotated text render.
+type watermark struct {
+	mask   *image.Alpha
+	offset float64 // Shadow offset the mask was placed for
+	height int     // Canvas height the mask was placed for
+}
+
+// newWatermark rasterises the language name for a window at offset
+func (r *Renderer) newWatermark(offset float64) *watermark {
+	text := strings.ToUpper(r.config.Language)
+	face := r.faces.kinetic
+	w, h := float64(r.config.Width), float64(r.config.Height)
+	cx, cy := offset+w/2, offset+h/2
+	x, y := offset+float64(r.config.Padding), h
+
+	// The rotated corners of the glyph box bound what the text covers
+	box, _ := font.BoundString(face, text)
+	sin, cos := math.Sincos(watermarkAngle)
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, px := range []float64{x + float64(box.Min.X)/64, x + float64(box.Max.X)/64} {
+		for _, py := range []float64{y + float64(box.Min.Y)/64, y + float64(box.Max.Y)/64} {
+			rx := cx + (px-cx)*cos - (py-cy)*sin
+			ry := cy + (px-cx)*sin + (py-cy)*cos
+			minX, maxX = math.Min(minX, rx), math.Max(maxX, rx)
+			minY, maxY = math.Min(minY, ry), math.Max(maxY, ry)
+		}
+	}
+	bounds := image.Rect(int(math.Floor(minX))-2, int(math.Floor(minY))-2, int(math.Ceil(maxX))+2, int(math.Ceil(maxY))+2)
+
+	dc := gg.NewContext(bounds.Dx(), bounds.Dy())
+	dc.Translate(-float64(bounds.Min.X), -float64(bounds.Min.Y))
+	dc.RotateAbout(watermarkAngle, cx, cy)
+	dc.SetFontFace(face)
+	dc.SetColor(color.Black)
+	dc.DrawString(text, x, y)
+
+	img := dc.Image().(*image.RGBA)
+	mask := image.NewAlpha(bounds)
+	for i := range mask.Pix {
+		mask.Pix[i] = img.Pix[4*i+3]
+	}
+	return &watermark{mask: mask, offset: offset, height: r.config.Height}
+}
+
+// drawWatermark blends the faint language name into the window body of
+// img at progress. It scrolls up half the window over the animation and
+// is clipped to the rounded body, as it is far larger than short windows.
+func (r *Renderer) drawWatermark(img *image.RGBA, offset, progress float64) {
+	if r.watermark == nil || r.watermark.offset != offset || r.watermark.height != r.config.Height {
+		r.watermark = r.newWatermark(offset)
+	}
+	mask := r.watermark.mask
+
+	// Very faint, dark text
+	alpha := uint32(12)
+	if r.config.Dark {
+		alpha = 40
+	}
+
+	// Moving the baseline up by d moves the tilted text along its own axis
+	d := -progress * float64(r.config.Height) * 0.5
+	sin, cos := math.Sincos(watermarkAngle)
+	shift := image.Pt(int(math.Round(-d*sin)), int(math.Round(d*cos)))
+
+	b := mask.Rect.Add(shift).Intersect(img.Bounds())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		x0, x1, ok := r.windowSpan(y, offset, offset)
+		if !ok {
+			continue
+		}
+		for x := max(x0, b.Min.X); x < min(x1, b.Max.X); x++ {
+			a := (uint32(mask.Pix[mask.PixOffset(x-shift.X, y-shift.Y)])*alpha + 127) / 255
+			if a == 0 {
+				continue
+			}
+			// Black over the premultiplied pixel
+			p := img.Pix[img.PixOffset(x, y):]
+			k := 255 - a
+			p[0] = uint8(uint32(p[0]) * k / 255)
+			p[1] = uint8(uint32(p[1]) * k / 255)
+			p[2] = uint8(uint32(p[2]) * k / 255)
+			p[3] = uint8(a + uint32(p[3])*k/255)
+		}
+	}
+}